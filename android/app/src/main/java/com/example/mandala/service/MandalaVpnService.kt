@@ -6,6 +6,7 @@ import android.app.NotificationChannel
 import android.app.NotificationManager
 import android.app.PendingIntent
 import android.content.Intent
+import android.net.ConnectivityManager
 import android.net.VpnService
 import android.os.Build
 import android.os.ParcelFileDescriptor
@@ -13,6 +14,7 @@ import androidx.core.app.NotificationCompat
 import com.example.mandala.MainActivity
 import com.example.mandala.R
 import mobile.Mobile
+import mobile.SocketProtector
 import java.io.IOException
 
 class MandalaVpnService : VpnService() {
@@ -84,6 +86,19 @@ class MandalaVpnService : VpnService() {
             .build()
     }
 
+    // 当前底层网络的 DNS 服务器，以逗号分隔；获取失败时使用公共 DNS
+    private fun underlyingDnsServers(): String {
+        val servers = try {
+            val cm = getSystemService(ConnectivityManager::class.java)
+            cm?.getLinkProperties(cm.activeNetwork)?.dnsServers
+                ?.mapNotNull { it.hostAddress }
+                .orEmpty()
+        } catch (e: Exception) {
+            emptyList()
+        }
+        return if (servers.isEmpty()) "8.8.8.8" else servers.joinToString(",")
+    }
+
     @Synchronized
     private fun startVpn(configJson: String) {
         if (isRunning) {
@@ -101,13 +116,19 @@ class MandalaVpnService : VpnService() {
                 vpnInterface = null
             }
 
+            // 核心发起的套接字 (代理服务器、直连、DoH) 逐个 protect 绕过 VPN，App 自身无需排除在外
+            Mobile.setSocketProtector(object : SocketProtector {
+                override fun protect(fd: Long): Boolean = this@MandalaVpnService.protect(fd.toInt())
+            })
+            // 需在 VPN 建立前读取底层网络的 DNS，供核心解析代理服务器域名
+            Mobile.setBootstrapDNS(underlyingDnsServers())
+
             val builder = Builder()
                 .addAddress(VPN_ADDRESS, 24)
                 .addRoute("0.0.0.0", 0)
                 .addRoute("::", 0)
                 .setMtu(1500)
                 .addDnsServer("8.8.8.8")
-                .addDisallowedApplication(packageName)
                 .setSession("Mandala Core")
             
             if (Build.VERSION.SDK_INT >= Build.VERSION_CODES.Q) {
@@ -153,6 +174,7 @@ class MandalaVpnService : VpnService() {
         
         try {
             Mobile.stop()
+            Mobile.setSocketProtector(null)
         } catch (e: Exception) {
             android.util.Log.e("MandalaVpn", "停止核心异常: ${e.message}")
         }
@@ -318,7 +318,7 @@ fun NodeEditDialog(node: Node?, onDismiss: () -> Unit, onSave: (Node) -> Unit) {
     var showAdvanced by remember { mutableStateOf(false) }
     var expandedProtocol by remember { mutableStateOf(false) }
 
-    val protocols = listOf("vless", "trojan", "shadowsocks", "socks5", "mandala")
+    val protocols = listOf("vless", "vmess", "trojan", "shadowsocks", "socks5", "mandala")
     val transports = listOf("tcp", "ws")
 
     AlertDialog(
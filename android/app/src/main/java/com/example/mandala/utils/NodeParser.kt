@@ -127,7 +127,7 @@ object NodeParser {
 
         return Node(
             tag = json.get("ps")?.asString?.let { Uri.decode(it) } ?: "未命名VMess",
-            protocol = "vless", // 保持源代码逻辑，使用 vless 协议栈处理 vmess
+            protocol = "vmess",
             server = json.get("add")?.asString ?: return null,
             port = port,
             uuid = json.get("id")?.asString ?: "",
@@ -566,6 +566,7 @@ class MainViewModel(application: Application) : AndroidViewModel(application) {
             "password": "${node.password}",
             "uuid": "${node.uuid}",
             "username": "${if(node.protocol == "socks5") node.uuid else ""}",
+            "method": "${if(node.protocol == "shadowsocks") node.uuid else ""}",
             "log_path": "$logPath",
             "tls": { 
                 "enabled": $useTls, 
@@ -9,7 +9,7 @@ import (
 // 对应原项目 config.c 中 ParseNodeConfigToGlobal 解析的字段
 type OutboundConfig struct {
 	Tag        string `json:"tag"`
-	Type       string `json:"type"` // 协议类型: "mandala", "vless", "trojan", "shadowsocks", "socks"
+	Type       string `json:"type"` // 协议类型: "mandala", "vless", "vmess", "trojan", "shadowsocks", "socks", "direct"; 分组: "selector", "urltest", "fallback", "loadbalance"
 	Server     string `json:"server"`
 	ServerPort int    `json:"server_port"`
 
@@ -17,6 +17,12 @@ type OutboundConfig struct {
 	UUID     string `json:"uuid,omitempty"`     // VLESS/VMess 使用
 	Password string `json:"password,omitempty"` // Mandala/Trojan/Shadowsocks 使用
 	Username string `json:"username,omitempty"` // SOCKS5 使用
+	Method   string `json:"method,omitempty"`   // Shadowsocks 加密方式，如 "aes-128-gcm"；为空表示不加密 (依赖外层 TLS)
+	Security string `json:"security,omitempty"` // VMess 加密方式: "auto", "aes-128-gcm", "chacha20-poly1305", "none"
+
+	// 代理链: 通过标签为 detour 的出站建立到本节点服务器的 TCP 连接
+	// (SOCKS5 的 UDP 中继与 Shadowsocks UDP 为独立的 UDP 连接，不经过 detour)
+	Detour string `json:"detour,omitempty"`
 
 	// 日志配置
 	LogPath string `json:"log_path,omitempty"` // 日志文件保存路径
@@ -31,6 +37,14 @@ type OutboundConfig struct {
 	// 高级配置
 	TLS       *TLSConfig       `json:"tls,omitempty"`
 	Transport *TransportConfig `json:"transport,omitempty"`
+
+	// 分组配置 (仅分组类型使用)
+	Outbounds []string `json:"outbounds,omitempty"` // 成员出站标签
+	Default   string   `json:"default,omitempty"`   // selector 初始选中的成员，默认第一个
+	URL       string   `json:"url,omitempty"`       // 测速/健康检查地址，默认 https://www.gstatic.com/generate_204
+	Interval  int      `json:"interval,omitempty"`  // 测速间隔 (秒)，urltest 默认 180，fallback/loadbalance 默认 60
+	Tolerance int      `json:"tolerance,omitempty"` // urltest 切换容差 (毫秒)，默认 50
+	Strategy  string   `json:"strategy,omitempty"`  // loadbalance 策略: "consistent-hashing" (默认) / "round-robin"
 }
 
 // TLSConfig 定义 TLS 相关配置
@@ -60,9 +74,114 @@ type Config struct {
 	// Android 端通常每次只选中一个节点运行，所以这里也可以简化为单个 OutboundConfig
 	CurrentNode *OutboundConfig `json:"current_node"`
 
+	// 多出站列表，通过 Tag 引用；CurrentNode 与列表第一项中靠前者为默认代理出站
+	Outbounds []OutboundConfig `json:"outbounds,omitempty"`
+
 	// 全局设置 (对应 set.ini 中的部分设置)
 	LocalPort int  `json:"local_port"`
 	Debug     bool `json:"debug"`
+
+	// 本地入站设置
+	ListenAddr   string   `json:"listen_addr,omitempty"`   // 监听地址，默认 127.0.0.1；设为 0.0.0.0 可供局域网使用
+	AuthUsername string   `json:"auth_username,omitempty"` // 入站用户名 (RFC 1929)，为空表示无需认证
+	AuthPassword string   `json:"auth_password,omitempty"` // 入站密码
+	AllowCIDRs   []string `json:"allow_cidrs,omitempty"`   // 允许的来源地址段，为空表示不限制
+	DenyCIDRs    []string `json:"deny_cidrs,omitempty"`    // 拒绝的来源地址段，优先于 AllowCIDRs
+
+	// 直连套接字选项 (仅 Linux)，用于 TUN 模式下避免直连流量回环
+	RoutingMark   int    `json:"routing_mark,omitempty"`   // SO_MARK 路由标记，配合策略路由使用
+	BindInterface string `json:"bind_interface,omitempty"` // SO_BINDTODEVICE 绑定的出口网卡，如 "eth0"
+	// 核心自身解析代理服务器与直连目标域名使用的 DNS 服务器 (如 "223.5.5.5")，为空时使用系统配置
+	BootstrapDNS []string `json:"bootstrap_dns,omitempty"`
+
+	// 路由规则，为空时所有流量走代理
+	Route *RouteConfig `json:"route,omitempty"`
+
+	// TUN 模式下的 DNS 设置
+	DNS *DNSConfig `json:"dns,omitempty"`
+
+	// TUN 模式下的协议嗅探
+	Sniff *SniffConfig `json:"sniff,omitempty"`
+}
+
+// SniffConfig 定义协议嗅探: 从 TLS ClientHello (SNI)、HTTP 请求 (Host) 与 QUIC Initial (SNI)
+// 中识别目标域名，用于仅有 IP 的连接
+type SniffConfig struct {
+	Enabled   bool     `json:"enabled"`
+	Protocols []string `json:"protocols,omitempty"` // 嗅探的协议: "tls" / "http" / "quic"，默认全部
+	// 以嗅探到的域名替换连接目标的协议，服务端将按域名连接；未列出的协议嗅探结果仅用于路由匹配
+	Override []string `json:"override,omitempty"`
+	Timeout  int      `json:"timeout,omitempty"` // 等待客户端首包的时间 (毫秒)，默认 300
+}
+
+// DNSConfig 定义 TUN 模式下 DNS 劫持的处理方式
+type DNSConfig struct {
+	// Fake-IP 模式: A 查询直接返回保留地址段中的虚假 IP，连接时再还原为域名交由服务端解析
+	FakeIP        bool     `json:"fake_ip"`
+	FakeIPRange   string   `json:"fake_ip_range,omitempty"`   // 虚假 IP 地址段，默认 198.18.0.0/15
+	FakeIPExclude []string `json:"fake_ip_exclude,omitempty"` // 不使用 Fake-IP 的域名后缀 (如局域网、NTP 域名)
+
+	// 上游服务器，第一个为默认上游；为空时经由代理以 TCP 查询 8.8.8.8
+	Servers []DNSServerConfig `json:"servers,omitempty"`
+	// 按域名后缀指定上游，按顺序匹配
+	Rules []DNSRuleConfig `json:"rules,omitempty"`
+
+	// 应答缓存，TTL 按 [CacheMinTTL, CacheMaxTTL] 截断 (秒，0 使用默认值 60 / 86400)
+	DisableCache bool   `json:"disable_cache,omitempty"`
+	CacheSize    int    `json:"cache_size,omitempty"` // 最大条目数，默认 4096
+	CacheMinTTL  uint32 `json:"cache_min_ttl,omitempty"`
+	CacheMaxTTL  uint32 `json:"cache_max_ttl,omitempty"`
+
+	// 附加到上游查询的 EDNS Client Subnet (如 "203.0.113.0/24")，使 CDN 按该网段返回就近节点
+	ClientSubnet string `json:"client_subnet,omitempty"`
+	// 禁用 IPv6: AAAA 查询直接返回空应答，并移除上游应答中的 AAAA 记录
+	DisableIPv6 bool `json:"disable_ipv6,omitempty"`
+	// 静态解析，域名 -> IP (多个地址以逗号分隔)，本地直接应答
+	Hosts map[string]string `json:"hosts,omitempty"`
+	// 命中 block 路由规则的域名的应答方式: "nxdomain" (默认) 或 "zero" (返回 0.0.0.0 / ::)
+	BlockMode string `json:"block_mode,omitempty"`
+}
+
+// DNSServerConfig 定义单个上游 DNS 服务器
+type DNSServerConfig struct {
+	Tag string `json:"tag"`
+	// 地址格式: "udp://1.1.1.1" (或直接写 "1.1.1.1")、"tcp://8.8.8.8:53"、
+	// "tls://dns.google" (DoT, 默认 853)、"https://1.1.1.1/dns-query" (DoH)
+	Address string `json:"address"`
+	// 查询经由的出站，与路由动作写法相同: "proxy" (默认)、"direct"、"proxy:<tag>"
+	Outbound string `json:"outbound,omitempty"`
+}
+
+// DNSRuleConfig 将匹配域名后缀的查询交给指定上游
+type DNSRuleConfig struct {
+	DomainSuffix []string `json:"domain_suffix"`
+	Server       string   `json:"server"` // 上游标签
+}
+
+// RouteConfig 定义路由规则
+type RouteConfig struct {
+	Rules []RuleConfig `json:"rules,omitempty"`
+	Final string       `json:"final,omitempty"` // 未命中任何规则时的动作，默认 "proxy"
+}
+
+// RuleConfig 定义单条路由规则，按顺序匹配，首个命中的规则生效
+// 同一字段内的多个值为"或"关系，不同字段之间为"与"关系；
+// 例外: 域名类字段与 ip_cidr 共同组成目标地址条件，彼此之间为"或"关系
+type RuleConfig struct {
+	Domain        []string `json:"domain,omitempty"`         // 完整域名
+	DomainSuffix  []string `json:"domain_suffix,omitempty"`  // 域名后缀，"example.com" 同时匹配其自身与子域名
+	DomainKeyword []string `json:"domain_keyword,omitempty"` // 域名关键字
+	DomainRegex   []string `json:"domain_regex,omitempty"`   // 域名正则
+	DomainList    []string `json:"domain_list,omitempty"`    // 域名列表文件路径，支持纯文本、hosts 与 AdGuard (||domain^) 格式
+	IPCIDR        []string `json:"ip_cidr,omitempty"`        // 目标 IP 地址段
+	Port          []string `json:"port,omitempty"`           // 目标端口，如 "443" 或 "1000-2000"
+	Network       []string `json:"network,omitempty"`        // "tcp" / "udp"
+	Inbound       []string `json:"inbound,omitempty"`        // "tun" / "socks4" / "socks5" / "socks" / "http"
+	PackageName   []string `json:"package_name,omitempty"`   // 发起连接的应用包名 (仅 TUN 模式，需设置连接归属查询)
+	UID           []int    `json:"uid,omitempty"`            // 发起连接的应用 UID，与 package_name 任一命中即可
+
+	// 动作: "direct" 直连, "proxy" 默认代理, "proxy:<tag>" 指定出站, "block" 拦截
+	Action string `json:"action"`
 }
 
 // ParseConfig 解析 JSON 字符串为配置对象
@@ -75,3 +194,20 @@ func ParseConfig(jsonStr string) (*OutboundConfig, error) {
 	}
 	return &cfg, nil
 }
+
+// ParseServerConfig 解析本地代理服务器的总配置
+// 兼容 Android 端的扁平格式：节点字段与 local_port 等全局字段位于同一层级
+func ParseServerConfig(jsonStr string) (*Config, error) {
+	var cfg Config
+	if err := json.Unmarshal([]byte(jsonStr), &cfg); err != nil {
+		return nil, fmt.Errorf("config parse error: %v", err)
+	}
+	if cfg.CurrentNode == nil && len(cfg.Outbounds) == 0 {
+		node, err := ParseConfig(jsonStr)
+		if err != nil {
+			return nil, err
+		}
+		cfg.CurrentNode = node
+	}
+	return &cfg, nil
+}
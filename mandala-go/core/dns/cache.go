@@ -0,0 +1,277 @@
+package dns
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mandala/core/config"
+
+	mdns "github.com/miekg/dns"
+)
+
+const (
+	defaultCacheSize   = 4096
+	defaultCacheMinTTL = 60
+	defaultCacheMaxTTL = 86400
+
+	// staleMaxAge 过期后仍可作为旧应答返回的时长 (RFC 8767)
+	staleMaxAge = time.Hour
+	// staleAnswerTTL 旧应答中记录的 TTL，促使客户端尽快重新查询
+	staleAnswerTTL = 1
+)
+
+// CacheStats 缓存统计
+type CacheStats struct {
+	Entries    int   `json:"entries"`
+	Hits       int64 `json:"hits"`
+	Misses     int64 `json:"misses"`
+	Stale      int64 `json:"stale"`      // 返回旧应答的次数
+	Prefetches int64 `json:"prefetches"` // 后台刷新次数
+}
+
+// Cache 为上游增加应答缓存，自身也实现 Upstream
+// 临近过期的条目在命中时后台预取；已过期的条目先返回旧应答再后台刷新；NXDOMAIN 与空应答按 SOA 做否定缓存
+type Cache struct {
+	upstream Upstream
+	size     int
+	minTTL   uint32
+	maxTTL   uint32
+
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	lru        *list.List // 元素为 *cacheEntry，队首为最近使用
+	refreshing map[string]bool
+
+	hits, misses, stale, prefetches int64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type cacheEntry struct {
+	key      string
+	msg      *mdns.Msg
+	stored   time.Time
+	ttl      time.Duration
+	expireAt time.Time
+}
+
+// NewCache 创建缓存，cfg 可为 nil
+func NewCache(cfg *config.DNSConfig, upstream Upstream) *Cache {
+	c := &Cache{
+		upstream:   upstream,
+		size:       defaultCacheSize,
+		minTTL:     defaultCacheMinTTL,
+		maxTTL:     defaultCacheMaxTTL,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		refreshing: make(map[string]bool),
+	}
+	if cfg != nil {
+		if cfg.CacheSize > 0 {
+			c.size = cfg.CacheSize
+		}
+		if cfg.CacheMinTTL > 0 {
+			c.minTTL = cfg.CacheMinTTL
+		}
+		if cfg.CacheMaxTTL > 0 {
+			c.maxTTL = cfg.CacheMaxTTL
+		}
+	}
+	if c.minTTL > c.maxTTL {
+		c.minTTL = c.maxTTL
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c
+}
+
+// Close 停止后台刷新
+func (c *Cache) Close() {
+	c.cancel()
+}
+
+// Stats 返回缓存统计
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	entries := c.lru.Len()
+	c.mu.Unlock()
+	return CacheStats{
+		Entries:    entries,
+		Hits:       atomic.LoadInt64(&c.hits),
+		Misses:     atomic.LoadInt64(&c.misses),
+		Stale:      atomic.LoadInt64(&c.stale),
+		Prefetches: atomic.LoadInt64(&c.prefetches),
+	}
+}
+
+// Exchange 优先从缓存应答，未命中时查询上游并缓存结果
+func (c *Cache) Exchange(ctx context.Context, msg *mdns.Msg) (*mdns.Msg, error) {
+	if len(msg.Question) != 1 {
+		return c.upstream.Exchange(ctx, msg)
+	}
+	key := cacheKey(msg.Question[0])
+	now := time.Now()
+
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*cacheEntry)
+		if now.Before(entry.expireAt) {
+			c.lru.MoveToFront(e)
+			// 剩余 TTL 不足十分之一时预取
+			prefetch := entry.expireAt.Sub(now) < entry.ttl/10
+			c.mu.Unlock()
+			atomic.AddInt64(&c.hits, 1)
+			if prefetch {
+				c.refresh(key, msg)
+			}
+			return entry.reply(msg, now), nil
+		}
+		if now.Sub(entry.expireAt) < staleMaxAge {
+			c.lru.MoveToFront(e)
+			c.mu.Unlock()
+			atomic.AddInt64(&c.stale, 1)
+			c.refresh(key, msg)
+			return entry.reply(msg, now), nil
+		}
+		c.remove(e)
+	}
+	c.mu.Unlock()
+
+	atomic.AddInt64(&c.misses, 1)
+	resp, err := c.upstream.Exchange(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	c.store(key, resp)
+	return resp, nil
+}
+
+// refresh 在后台重新查询，同一条目同时只有一个刷新任务
+func (c *Cache) refresh(key string, msg *mdns.Msg) {
+	c.mu.Lock()
+	if c.refreshing[key] {
+		c.mu.Unlock()
+		return
+	}
+	c.refreshing[key] = true
+	c.mu.Unlock()
+	atomic.AddInt64(&c.prefetches, 1)
+
+	query := msg.Copy()
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.refreshing, key)
+			c.mu.Unlock()
+		}()
+		ctx, cancel := context.WithTimeout(c.ctx, queryTimeout)
+		defer cancel()
+		if resp, err := c.upstream.Exchange(ctx, query); err == nil {
+			c.store(key, resp)
+		}
+	}()
+}
+
+// store 缓存可缓存的应答：成功 (含空应答) 与 NXDOMAIN，不缓存截断报文
+func (c *Cache) store(key string, resp *mdns.Msg) {
+	if resp.Truncated || (resp.Rcode != mdns.RcodeSuccess && resp.Rcode != mdns.RcodeNameError) {
+		return
+	}
+	ttl := c.clamp(responseTTL(resp))
+	now := time.Now()
+	entry := &cacheEntry{
+		key:      key,
+		msg:      resp.Copy(),
+		stored:   now,
+		ttl:      ttl,
+		expireAt: now.Add(ttl),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		e.Value = entry
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove 删除条目，调用方需持有锁
+func (c *Cache) remove(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.entries, e.Value.(*cacheEntry).key)
+}
+
+func (c *Cache) clamp(ttl uint32) time.Duration {
+	if ttl < c.minTTL {
+		ttl = c.minTTL
+	}
+	if ttl > c.maxTTL {
+		ttl = c.maxTTL
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// reply 以缓存的应答构造回复，记录的 TTL 扣除已缓存的时长且不超过条目剩余有效期
+func (e *cacheEntry) reply(req *mdns.Msg, now time.Time) *mdns.Msg {
+	resp := e.msg.Copy()
+	resp.Id = req.Id
+	elapsed := uint32(now.Sub(e.stored) / time.Second)
+	remaining := uint32(staleAnswerTTL)
+	if left := e.expireAt.Sub(now); left > time.Second {
+		remaining = uint32(left / time.Second)
+	}
+	for _, section := range [][]mdns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range section {
+			hdr := rr.Header()
+			if hdr.Rrtype == mdns.TypeOPT {
+				continue
+			}
+			ttl := uint32(staleAnswerTTL)
+			if hdr.Ttl > elapsed {
+				ttl = hdr.Ttl - elapsed
+			}
+			if ttl > remaining {
+				ttl = remaining
+			}
+			hdr.Ttl = ttl
+		}
+	}
+	return resp
+}
+
+// responseTTL 返回应答的有效期：肯定应答取应答记录的最小 TTL；
+// 否定应答 (NXDOMAIN / 空应答) 按 RFC 2308 取 SOA 的 TTL 与 MINIMUM 的较小值
+func responseTTL(resp *mdns.Msg) uint32 {
+	if resp.Rcode == mdns.RcodeSuccess && len(resp.Answer) > 0 {
+		ttl := resp.Answer[0].Header().Ttl
+		for _, rr := range resp.Answer[1:] {
+			if rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+			}
+		}
+		return ttl
+	}
+	for _, rr := range resp.Ns {
+		if soa, ok := rr.(*mdns.SOA); ok {
+			if soa.Minttl < soa.Hdr.Ttl {
+				return soa.Minttl
+			}
+			return soa.Hdr.Ttl
+		}
+	}
+	return 0
+}
+
+func cacheKey(q mdns.Question) string {
+	return fmt.Sprintf("%s/%d/%d", strings.ToLower(q.Name), q.Qtype, q.Qclass)
+}
@@ -0,0 +1,117 @@
+package dns
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// DefaultFakeIPRange 默认虚假 IP 地址段 (RFC 2544 基准测试保留段)
+const DefaultFakeIPRange = "198.18.0.0/15"
+
+// FakeIPPool 维护虚假 IP 与域名的双向映射
+// 地址耗尽时回收最久未使用的映射 (LRU)
+type FakeIPPool struct {
+	network *net.IPNet
+	base    uint32 // 地址段起始地址
+	size    uint32 // 可分配地址数
+
+	mu       sync.Mutex
+	byDomain map[string]*list.Element
+	byOffset map[uint32]*list.Element
+	lru      *list.List // 元素为 *fakeIPEntry，队首为最近使用
+	next     uint32     // 下一个未分配过的偏移
+}
+
+type fakeIPEntry struct {
+	domain string
+	offset uint32
+}
+
+// NewFakeIPPool 创建虚假 IP 池，仅支持 IPv4 地址段
+func NewFakeIPPool(cidr string) (*FakeIPPool, error) {
+	if cidr == "" {
+		cidr = DefaultFakeIPRange
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fake ip range: %v", err)
+	}
+	ip4 := network.IP.To4()
+	ones, bits := network.Mask.Size()
+	if ip4 == nil || bits != 32 || ones > 30 {
+		return nil, fmt.Errorf("invalid fake ip range: %s (need IPv4, at most /30)", cidr)
+	}
+
+	// 跳过网络地址与第一个地址 (常用作网关)，以及广播地址
+	size := uint32(1)<<uint(32-ones) - 3
+	return &FakeIPPool{
+		network:  network,
+		base:     binary.BigEndian.Uint32(ip4) + 2,
+		size:     size,
+		byDomain: make(map[string]*list.Element),
+		byOffset: make(map[uint32]*list.Element),
+		lru:      list.New(),
+	}, nil
+}
+
+// Contains 判断 ip 是否属于虚假地址段
+func (p *FakeIPPool) Contains(ip net.IP) bool {
+	return p.network.Contains(ip)
+}
+
+// Lookup 返回域名对应的虚假 IP，不存在时分配一个
+func (p *FakeIPPool) Lookup(domain string) net.IP {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if e, ok := p.byDomain[domain]; ok {
+		p.lru.MoveToFront(e)
+		return p.ip(e.Value.(*fakeIPEntry).offset)
+	}
+
+	var offset uint32
+	if p.next < p.size {
+		offset = p.next
+		p.next++
+	} else {
+		// 地址耗尽，回收最久未使用的映射
+		oldest := p.lru.Back()
+		entry := oldest.Value.(*fakeIPEntry)
+		p.lru.Remove(oldest)
+		delete(p.byDomain, entry.domain)
+		delete(p.byOffset, entry.offset)
+		offset = entry.offset
+	}
+
+	e := p.lru.PushFront(&fakeIPEntry{domain: domain, offset: offset})
+	p.byDomain[domain] = e
+	p.byOffset[offset] = e
+	return p.ip(offset)
+}
+
+// Domain 返回虚假 IP 对应的域名
+func (p *FakeIPPool) Domain(ip net.IP) (string, bool) {
+	ip4 := ip.To4()
+	if ip4 == nil || !p.network.Contains(ip4) {
+		return "", false
+	}
+	offset := binary.BigEndian.Uint32(ip4) - p.base
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.byOffset[offset]
+	if !ok {
+		return "", false
+	}
+	p.lru.MoveToFront(e)
+	return e.Value.(*fakeIPEntry).domain, true
+}
+
+func (p *FakeIPPool) ip(offset uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, p.base+offset)
+	return ip
+}
@@ -0,0 +1,199 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"mandala/core/config"
+
+	mdns "github.com/miekg/dns"
+)
+
+// fakeIPTTL Fake-IP 应答的 TTL，保持较小以便映射被回收后客户端能及时重新查询
+const fakeIPTTL = 1
+
+// Handler 处理 TUN 中劫持到的 DNS 查询
+type Handler struct {
+	upstream    Upstream
+	fakeIP      *FakeIPPool // 为 nil 表示未启用 Fake-IP
+	exclude     []string
+	hosts       map[string][]net.IP
+	ecs         *mdns.EDNS0_SUBNET // 为 nil 表示不附加 ECS
+	disableIPv6 bool
+
+	blocked   func(domain string) bool // 为 nil 表示不拦截
+	blockZero bool                     // 拦截时返回 0.0.0.0 / :: 而非 NXDOMAIN
+}
+
+// NewHandler 根据配置创建 DNS 处理器，cfg 可为 nil
+func NewHandler(cfg *config.DNSConfig, upstream Upstream) (*Handler, error) {
+	h := &Handler{upstream: upstream}
+	if cfg == nil {
+		return h, nil
+	}
+	if cfg.FakeIP {
+		pool, err := NewFakeIPPool(cfg.FakeIPRange)
+		if err != nil {
+			return nil, err
+		}
+		h.fakeIP = pool
+		log.Printf("[DNS] Fake-IP 已启用: %s", pool.network)
+	}
+	for _, d := range cfg.FakeIPExclude {
+		h.exclude = append(h.exclude, strings.Trim(strings.ToLower(d), "."))
+	}
+
+	hosts, err := parseHosts(cfg.Hosts)
+	if err != nil {
+		return nil, err
+	}
+	h.hosts = hosts
+	if cfg.ClientSubnet != "" {
+		if h.ecs, err = parseClientSubnet(cfg.ClientSubnet); err != nil {
+			return nil, err
+		}
+		log.Printf("[DNS] ECS 已启用: %s/%d", h.ecs.Address, h.ecs.SourceNetmask)
+	}
+	h.disableIPv6 = cfg.DisableIPv6
+
+	switch strings.ToLower(cfg.BlockMode) {
+	case "", "nxdomain":
+	case "zero":
+		h.blockZero = true
+	default:
+		return nil, fmt.Errorf("unknown dns block_mode: %s", cfg.BlockMode)
+	}
+	return h, nil
+}
+
+// SetBlocker 设置域名拦截判定 (通常为路由器的 BlocksDomain)，命中的查询不再发往上游
+func (h *Handler) SetBlocker(blocked func(domain string) bool) {
+	h.blocked = blocked
+}
+
+// RestoreDomain 将虚假 IP 还原为域名
+// fake 表示 ip 属于虚假地址段；此时 domain 为空说明映射已被回收
+func (h *Handler) RestoreDomain(ip net.IP) (domain string, fake bool) {
+	if h.fakeIP == nil || !h.fakeIP.Contains(ip) {
+		return "", false
+	}
+	domain, _ = h.fakeIP.Domain(ip)
+	return domain, true
+}
+
+// Exchange 处理一条原始 DNS 查询报文，返回应答报文
+func (h *Handler) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	req := new(mdns.Msg)
+	if err := req.Unpack(query); err != nil {
+		return nil, err
+	}
+
+	resp, err := h.exchangeMsg(ctx, req)
+	if err != nil {
+		// 返回 SERVFAIL，避免客户端一直等待超时
+		log.Printf("[DNS] 查询失败: %v", err)
+		resp = new(mdns.Msg)
+		resp.SetRcode(req, mdns.RcodeServerFailure)
+	}
+	resp.Id = req.Id
+	if req.IsEdns0() == nil {
+		stripOPT(resp)
+	}
+	// 应答经 UDP 返回，超出客户端声明的大小时截断并设置 TC 位
+	resp.Truncate(udpSize(req))
+	return resp.Pack()
+}
+
+// udpSize 返回客户端可接收的 UDP 应答大小
+func udpSize(req *mdns.Msg) int {
+	if opt := req.IsEdns0(); opt != nil && opt.UDPSize() > mdns.MinMsgSize {
+		return int(opt.UDPSize())
+	}
+	return mdns.MinMsgSize
+}
+
+// exchangeMsg 依次尝试 IPv6 屏蔽、静态解析、拦截与 Fake-IP，均不适用时查询上游
+func (h *Handler) exchangeMsg(ctx context.Context, req *mdns.Msg) (*mdns.Msg, error) {
+	if len(req.Question) == 1 {
+		q := req.Question[0]
+		isAddr := q.Qclass == mdns.ClassINET && (q.Qtype == mdns.TypeA || q.Qtype == mdns.TypeAAAA)
+		if h.disableIPv6 && q.Qtype == mdns.TypeAAAA {
+			return emptyAnswer(req), nil
+		}
+		domain := strings.TrimSuffix(strings.ToLower(q.Name), ".")
+		if ips, ok := h.hosts[domain]; ok && isAddr {
+			return answerHosts(req, ips), nil
+		}
+		if h.blocked != nil && domain != "" && h.blocked(domain) {
+			log.Printf("[DNS] 拦截: %s", domain)
+			return h.answerBlocked(req), nil
+		}
+		if h.fakeIP != nil {
+			if resp := h.answerFakeIP(req); resp != nil {
+				return resp, nil
+			}
+		}
+	}
+
+	query := req
+	if h.ecs != nil {
+		query = withClientSubnet(req, h.ecs)
+	}
+	resp, err := h.upstream.Exchange(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if h.disableIPv6 {
+		stripAAAA(resp)
+	}
+	return resp, nil
+}
+
+// answerFakeIP 为 A 查询分配虚假 IP；AAAA 返回空应答使客户端回落到 IPv4
+// 不适用时返回 nil，交由上游处理
+func (h *Handler) answerFakeIP(req *mdns.Msg) *mdns.Msg {
+	q := req.Question[0]
+	if q.Qclass != mdns.ClassINET || (q.Qtype != mdns.TypeA && q.Qtype != mdns.TypeAAAA) {
+		return nil
+	}
+	domain := strings.TrimSuffix(strings.ToLower(q.Name), ".")
+	if domain == "" || h.excluded(domain) {
+		return nil
+	}
+
+	resp := emptyAnswer(req)
+	if q.Qtype == mdns.TypeA {
+		resp.Answer = []mdns.RR{&mdns.A{
+			Hdr: mdns.RR_Header{Name: q.Name, Rrtype: mdns.TypeA, Class: mdns.ClassINET, Ttl: fakeIPTTL},
+			A:   h.fakeIP.Lookup(domain),
+		}}
+	}
+	return resp
+}
+
+// answerBlocked 构造拦截应答: NXDOMAIN，或对 A/AAAA 返回未指定地址
+func (h *Handler) answerBlocked(req *mdns.Msg) *mdns.Msg {
+	q := req.Question[0]
+	if !h.blockZero {
+		resp := new(mdns.Msg)
+		resp.SetRcode(req, mdns.RcodeNameError)
+		resp.RecursionAvailable = true
+		return resp
+	}
+	if q.Qclass != mdns.ClassINET {
+		return emptyAnswer(req)
+	}
+	return answerHosts(req, []net.IP{net.IPv4zero, net.IPv6zero})
+}
+
+func (h *Handler) excluded(domain string) bool {
+	for _, suffix := range h.exclude {
+		if domain == suffix || strings.HasSuffix(domain, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
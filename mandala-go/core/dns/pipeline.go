@@ -0,0 +1,232 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// pipelineIdleTimeout 会话空闲多久后关闭 (RFC 7766 6.2.3)
+const pipelineIdleTimeout = 60 * time.Second
+
+var errSessionClosed = errors.New("dns session closed")
+
+// pipelineUpstream 在一条长连接上并发发送多条查询 (RFC 7766 6.2.1.1)，按消息 ID 分发应答
+// 连接断开后下一次查询时重新建立；拨号在锁外进行，并发查询共享同一次拨号
+type pipelineUpstream struct {
+	dial func(ctx context.Context) (net.Conn, error)
+
+	mu      sync.Mutex
+	sess    *pipelineSession
+	dialing *pipelineDial // 正在进行的拨号，为 nil 表示没有
+}
+
+// pipelineDial 一次进行中的拨号，done 关闭后 sess / err 可读
+type pipelineDial struct {
+	done chan struct{}
+	sess *pipelineSession
+	err  error
+}
+
+func newPipelineUpstream(dial func(ctx context.Context) (net.Conn, error)) *pipelineUpstream {
+	return &pipelineUpstream{dial: dial}
+}
+
+func (u *pipelineUpstream) Exchange(ctx context.Context, msg *mdns.Msg) (*mdns.Msg, error) {
+	sess, fresh, err := u.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := sess.exchange(ctx, msg)
+	if err == errSessionClosed && !fresh && ctx.Err() == nil {
+		// 复用的连接可能已被服务端关闭，换新连接重试一次
+		if sess, _, err = u.session(ctx); err != nil {
+			return nil, err
+		}
+		resp, err = sess.exchange(ctx, msg)
+	}
+	return resp, err
+}
+
+// session 返回可用会话，fresh 表示本次新建
+// 没有可用会话时发起拨号 (已有拨号进行中则等待其结果)，等待期间 ctx 取消即返回
+func (u *pipelineUpstream) session(ctx context.Context) (*pipelineSession, bool, error) {
+	u.mu.Lock()
+	if u.sess != nil && !u.sess.isClosed() {
+		sess := u.sess
+		u.mu.Unlock()
+		return sess, false, nil
+	}
+	d := u.dialing
+	if d == nil {
+		d = &pipelineDial{done: make(chan struct{})}
+		u.dialing = d
+		// 拨号不随发起者的 ctx 取消 (其他查询也在等待)，但保留 ctx 中的值 (如 ECH 查询标记)
+		go u.connect(context.WithoutCancel(ctx), d)
+	}
+	u.mu.Unlock()
+
+	select {
+	case <-d.done:
+		return d.sess, true, d.err
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+// connect 执行拨号并发布会话；期间 Close 过则关闭新会话，等待者将得到 errSessionClosed
+func (u *pipelineUpstream) connect(ctx context.Context, d *pipelineDial) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	conn, err := u.dial(ctx)
+	if err == nil {
+		d.sess = newPipelineSession(conn)
+	}
+	d.err = err
+
+	u.mu.Lock()
+	if u.dialing == d {
+		u.dialing = nil
+		if d.sess != nil {
+			u.sess = d.sess
+		}
+	} else if d.sess != nil {
+		d.sess.close()
+	}
+	u.mu.Unlock()
+	close(d.done)
+}
+
+// Close 关闭当前会话，进行中的拨号完成后立即关闭
+func (u *pipelineUpstream) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.sess != nil {
+		u.sess.close()
+		u.sess = nil
+	}
+	u.dialing = nil
+	return nil
+}
+
+type pipelineSession struct {
+	conn *mdns.Conn
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[uint16]chan *mdns.Msg
+	nextID  uint16
+
+	lastRead  int64 // 最近一次收到应答的时间 (UnixNano)
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipelineSession(conn net.Conn) *pipelineSession {
+	s := &pipelineSession{
+		conn:     &mdns.Conn{Conn: conn},
+		pending:  make(map[uint16]chan *mdns.Msg),
+		nextID:   mdns.Id(),
+		lastRead: time.Now().UnixNano(),
+		closed:   make(chan struct{}),
+	}
+	go s.readLoop()
+	return s
+}
+
+func (s *pipelineSession) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *pipelineSession) close() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.conn.Close()
+	})
+}
+
+// exchange 以会话内唯一的 ID 发送查询 (客户端的 ID 可能冲突)，应答返回前恢复原 ID
+func (s *pipelineSession) exchange(ctx context.Context, msg *mdns.Msg) (*mdns.Msg, error) {
+	ch := make(chan *mdns.Msg, 1)
+	s.mu.Lock()
+	if len(s.pending) >= 0xFFFF {
+		s.mu.Unlock()
+		return nil, errors.New("too many pending dns queries")
+	}
+	id := s.nextID
+	for {
+		if _, used := s.pending[id]; !used {
+			break
+		}
+		id++
+	}
+	s.nextID = id + 1
+	s.pending[id] = ch
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, id)
+		s.mu.Unlock()
+	}()
+
+	query := msg.Copy()
+	query.Id = id
+	sent := time.Now().UnixNano()
+
+	s.writeMu.Lock()
+	s.conn.SetWriteDeadline(time.Now().Add(queryTimeout))
+	err := s.conn.WriteMsg(query)
+	// 每次发送后顺延读超时，长时间无查询时由 readLoop 关闭会话
+	s.conn.SetReadDeadline(time.Now().Add(pipelineIdleTimeout))
+	s.writeMu.Unlock()
+	if err != nil {
+		s.close()
+		return nil, errSessionClosed
+	}
+
+	select {
+	case resp := <-ch:
+		resp.Id = msg.Id
+		return resp, nil
+	case <-s.closed:
+		return nil, errSessionClosed
+	case <-ctx.Done():
+		// 发送后未收到过任何应答，连接可能已失效
+		if atomic.LoadInt64(&s.lastRead) < sent {
+			s.close()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (s *pipelineSession) readLoop() {
+	defer s.close()
+	for {
+		resp, err := s.conn.ReadMsg()
+		if err != nil {
+			return
+		}
+		atomic.StoreInt64(&s.lastRead, time.Now().UnixNano())
+
+		s.mu.Lock()
+		ch, ok := s.pending[resp.Id]
+		s.mu.Unlock()
+		if ok {
+			select {
+			case ch <- resp:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"mandala/core/config"
+	"mandala/core/protocol"
+
+	mdns "github.com/miekg/dns"
+)
+
+// OutboundFunc 按动作字符串 ("proxy" / "direct" / "proxy:<tag>") 返回出站
+type OutboundFunc func(action string) (protocol.Outbound, error)
+
+// Resolver 按域名后缀规则将查询分发到不同上游
+type Resolver struct {
+	def     Upstream
+	servers map[string]Upstream
+	rules   []resolverRule
+}
+
+type resolverRule struct {
+	suffixes []string
+	upstream Upstream
+}
+
+// NewResolver 根据配置创建解析器
+// cfg 为 nil 或未配置上游时，经由默认代理以 TCP 查询 8.8.8.8
+func NewResolver(cfg *config.DNSConfig, outboundFor OutboundFunc) (*Resolver, error) {
+	r := &Resolver{servers: make(map[string]Upstream)}
+
+	if cfg == nil || len(cfg.Servers) == 0 {
+		ob, err := outboundFor("proxy")
+		if err != nil {
+			return nil, err
+		}
+		r.def = NewTCPUpstream(protocol.Destination{Host: "8.8.8.8", Port: 53}, ob)
+		return r, nil
+	}
+
+	for i, sc := range cfg.Servers {
+		ob, err := outboundFor(sc.Outbound)
+		if err != nil {
+			return nil, fmt.Errorf("dns server %s: %v", sc.Tag, err)
+		}
+		up, err := NewUpstream(sc.Address, ob)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			r.def = up
+		}
+		if sc.Tag != "" {
+			if _, dup := r.servers[sc.Tag]; dup {
+				return nil, fmt.Errorf("duplicate dns server tag: %s", sc.Tag)
+			}
+			r.servers[sc.Tag] = up
+		}
+	}
+
+	for i, rc := range cfg.Rules {
+		up, ok := r.servers[rc.Server]
+		if !ok {
+			return nil, fmt.Errorf("dns rule %d: unknown server: %s", i, rc.Server)
+		}
+		rule := resolverRule{upstream: up}
+		for _, s := range rc.DomainSuffix {
+			rule.suffixes = append(rule.suffixes, strings.Trim(strings.ToLower(s), "."))
+		}
+		r.rules = append(r.rules, rule)
+	}
+	log.Printf("[DNS] 已加载 %d 个上游, %d 条规则", len(cfg.Servers), len(r.rules))
+	return r, nil
+}
+
+// Exchange 按问题中的域名选择上游并查询
+func (r *Resolver) Exchange(ctx context.Context, msg *mdns.Msg) (*mdns.Msg, error) {
+	return r.upstreamFor(msg).Exchange(ctx, msg)
+}
+
+// Close 关闭各上游持有的长连接
+func (r *Resolver) Close() error {
+	ups := []Upstream{r.def}
+	for _, up := range r.servers {
+		ups = append(ups, up)
+	}
+	closed := make(map[Upstream]bool)
+	for _, up := range ups {
+		if c, ok := up.(io.Closer); ok && !closed[up] {
+			closed[up] = true
+			c.Close()
+		}
+	}
+	return nil
+}
+
+func (r *Resolver) upstreamFor(msg *mdns.Msg) Upstream {
+	if len(msg.Question) == 0 {
+		return r.def
+	}
+	name := strings.TrimSuffix(strings.ToLower(msg.Question[0].Name), ".")
+	for _, rule := range r.rules {
+		for _, suffix := range rule.suffixes {
+			if name == suffix || strings.HasSuffix(name, "."+suffix) {
+				return rule.upstream
+			}
+		}
+	}
+	return r.def
+}
@@ -0,0 +1,129 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	mdns "github.com/miekg/dns"
+)
+
+// hostsTTL 静态解析应答的 TTL
+const hostsTTL = 60
+
+// ednsUDPSize 附加 OPT 记录时声明的 UDP 报文大小 (DNS Flag Day 2020 建议值)
+const ednsUDPSize = 1232
+
+// parseHosts 解析静态解析表，值为逗号分隔的 IP 列表
+func parseHosts(hosts map[string]string) (map[string][]net.IP, error) {
+	table := make(map[string][]net.IP, len(hosts))
+	for domain, value := range hosts {
+		domain = strings.Trim(strings.ToLower(domain), ".")
+		for _, s := range strings.Split(value, ",") {
+			ip := net.ParseIP(strings.TrimSpace(s))
+			if ip == nil {
+				return nil, fmt.Errorf("invalid hosts entry: %s -> %s", domain, value)
+			}
+			table[domain] = append(table[domain], ip)
+		}
+	}
+	return table, nil
+}
+
+// parseClientSubnet 将 CIDR 解析为 EDNS Client Subnet 选项 (RFC 7871)
+func parseClientSubnet(cidr string) (*mdns.EDNS0_SUBNET, error) {
+	if !strings.Contains(cidr, "/") {
+		if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+			cidr += "/24"
+		} else {
+			cidr += "/56"
+		}
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client subnet: %v", err)
+	}
+	ones, _ := network.Mask.Size()
+	ecs := &mdns.EDNS0_SUBNET{Code: mdns.EDNS0SUBNET, SourceNetmask: uint8(ones)}
+	if ip4 := network.IP.To4(); ip4 != nil {
+		ecs.Family = 1
+		ecs.Address = ip4
+	} else {
+		ecs.Family = 2
+		ecs.Address = network.IP
+	}
+	return ecs, nil
+}
+
+// answerHosts 以静态解析表中的地址应答 A/AAAA 查询，该地址族无记录时返回空应答
+func answerHosts(req *mdns.Msg, ips []net.IP) *mdns.Msg {
+	q := req.Question[0]
+	resp := new(mdns.Msg)
+	resp.SetReply(req)
+	resp.RecursionAvailable = true
+	for _, ip := range ips {
+		hdr := mdns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: mdns.ClassINET, Ttl: hostsTTL}
+		if ip4 := ip.To4(); ip4 != nil && q.Qtype == mdns.TypeA {
+			resp.Answer = append(resp.Answer, &mdns.A{Hdr: hdr, A: ip4})
+		} else if ip4 == nil && q.Qtype == mdns.TypeAAAA {
+			resp.Answer = append(resp.Answer, &mdns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	}
+	return resp
+}
+
+// emptyAnswer 构造无记录的成功应答 (NODATA)
+func emptyAnswer(req *mdns.Msg) *mdns.Msg {
+	resp := new(mdns.Msg)
+	resp.SetReply(req)
+	resp.RecursionAvailable = true
+	return resp
+}
+
+// withClientSubnet 返回附加了 ECS 选项的查询副本，替换客户端自带的 ECS
+func withClientSubnet(req *mdns.Msg, ecs *mdns.EDNS0_SUBNET) *mdns.Msg {
+	query := req.Copy()
+	opt := query.IsEdns0()
+	if opt == nil {
+		query.SetEdns0(ednsUDPSize, false)
+		opt = query.IsEdns0()
+	}
+	options := opt.Option[:0]
+	for _, o := range opt.Option {
+		if o.Option() != mdns.EDNS0SUBNET {
+			options = append(options, o)
+		}
+	}
+	opt.Option = append(options, ecs)
+	return query
+}
+
+// stripAAAA 移除应答中的 AAAA 记录
+func stripAAAA(resp *mdns.Msg) {
+	answers := resp.Answer[:0]
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype != mdns.TypeAAAA {
+			answers = append(answers, rr)
+		}
+	}
+	resp.Answer = answers
+
+	extra := resp.Extra[:0]
+	for _, rr := range resp.Extra {
+		if rr.Header().Rrtype != mdns.TypeAAAA {
+			extra = append(extra, rr)
+		}
+	}
+	resp.Extra = extra
+}
+
+// stripOPT 移除应答中的 OPT 记录，用于客户端查询未携带 EDNS 的情况
+func stripOPT(resp *mdns.Msg) {
+	extra := resp.Extra[:0]
+	for _, rr := range resp.Extra {
+		if rr.Header().Rrtype != mdns.TypeOPT {
+			extra = append(extra, rr)
+		}
+	}
+	resp.Extra = extra
+}
@@ -0,0 +1,209 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"mandala/core/protocol"
+
+	mdns "github.com/miekg/dns"
+)
+
+// queryTimeout 单次上游查询的超时时间
+const queryTimeout = 5 * time.Second
+
+// Upstream 向上游 DNS 服务器发送查询
+type Upstream interface {
+	Exchange(ctx context.Context, msg *mdns.Msg) (*mdns.Msg, error)
+}
+
+// NewUpstream 根据地址创建上游，所有连接经由 outbound 建立
+// 支持 "udp://"、"tcp://"、"tls://"、"https://" 前缀，无前缀按 UDP 处理
+func NewUpstream(address string, outbound protocol.Outbound) (Upstream, error) {
+	scheme, rest := "udp", address
+	if i := strings.Index(address, "://"); i >= 0 {
+		scheme, rest = strings.ToLower(address[:i]), address[i+3:]
+	}
+
+	switch scheme {
+	case "udp":
+		server, err := parseServer(rest, 53)
+		if err != nil {
+			return nil, err
+		}
+		return &udpUpstream{server: server, outbound: outbound}, nil
+	case "tcp":
+		server, err := parseServer(rest, 53)
+		if err != nil {
+			return nil, err
+		}
+		return NewTCPUpstream(server, outbound), nil
+	case "tls":
+		server, err := parseServer(rest, 853)
+		if err != nil {
+			return nil, err
+		}
+		return newTLSUpstream(server, outbound), nil
+	case "https":
+		return newHTTPSUpstream(address, outbound)
+	}
+	return nil, fmt.Errorf("unsupported dns upstream: %s", address)
+}
+
+// parseServer 解析 host[:port]，缺省使用 defaultPort
+func parseServer(hostport string, defaultPort int) (protocol.Destination, error) {
+	hostport = strings.TrimSuffix(hostport, "/")
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return protocol.Destination{Host: strings.Trim(hostport, "[]"), Port: defaultPort}, nil
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return protocol.Destination{}, fmt.Errorf("invalid dns server: %s", hostport)
+	}
+	return protocol.Destination{Host: host, Port: port}, nil
+}
+
+// withDeadline 按 ctx 设置连接超时，ctx 无截止时间时使用 queryTimeout
+func withDeadline(ctx context.Context, conn net.Conn) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(queryTimeout)
+	}
+	conn.SetDeadline(deadline)
+}
+
+// udpUpstream 通过出站的 UDP 会话查询
+type udpUpstream struct {
+	server   protocol.Destination
+	outbound protocol.Outbound
+}
+
+func (u *udpUpstream) Exchange(ctx context.Context, msg *mdns.Msg) (*mdns.Msg, error) {
+	conn, err := u.outbound.DialUDP(ctx, u.server)
+	if err != nil {
+		return nil, fmt.Errorf("dns dial %s failed: %v", u.server, err)
+	}
+	defer conn.Close()
+	withDeadline(ctx, conn)
+
+	query, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, mdns.MaxMsgSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		resp := new(mdns.Msg)
+		if err := resp.Unpack(buf[:n]); err != nil || resp.Id != msg.Id {
+			continue // 忽略无法解析或不匹配的报文
+		}
+		return resp, nil
+	}
+}
+
+// NewTCPUpstream 创建经由 outbound 的 TCP 上游，查询在一条长连接上流水线发送
+func NewTCPUpstream(server protocol.Destination, outbound protocol.Outbound) Upstream {
+	return newPipelineUpstream(func(ctx context.Context) (net.Conn, error) {
+		conn, err := outbound.DialTCP(ctx, server)
+		if err != nil {
+			return nil, fmt.Errorf("dns dial %s failed: %v", server, err)
+		}
+		return conn, nil
+	})
+}
+
+// newTLSUpstream 创建 DNS-over-TLS 上游 (RFC 7858)，与 TCP 一样复用长连接
+func newTLSUpstream(server protocol.Destination, outbound protocol.Outbound) Upstream {
+	return newPipelineUpstream(func(ctx context.Context) (net.Conn, error) {
+		conn, err := outbound.DialTCP(ctx, server)
+		if err != nil {
+			return nil, fmt.Errorf("dns dial %s failed: %v", server, err)
+		}
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: server.Host})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("dns tls handshake failed: %v", err)
+		}
+		return tlsConn, nil
+	})
+}
+
+// httpsUpstream DNS-over-HTTPS (RFC 8484)，复用 HTTP 连接
+type httpsUpstream struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPSUpstream(rawURL string, outbound protocol.Outbound) (Upstream, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid doh url: %s", rawURL)
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			server, err := parseServer(addr, 443)
+			if err != nil {
+				return nil, err
+			}
+			return outbound.DialTCP(ctx, server)
+		},
+		ForceAttemptHTTP2:     true,
+		IdleConnTimeout:       90 * time.Second,
+		ResponseHeaderTimeout: queryTimeout,
+	}
+	return &httpsUpstream{url: rawURL, client: &http.Client{Transport: transport}}, nil
+}
+
+func (u *httpsUpstream) Exchange(ctx context.Context, msg *mdns.Msg) (*mdns.Msg, error) {
+	// RFC 8484 建议 ID 置 0 以提高缓存命中率
+	query := msg.Copy()
+	query.Id = 0
+	data, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh status: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, mdns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+	reply := new(mdns.Msg)
+	if err := reply.Unpack(body); err != nil {
+		return nil, err
+	}
+	reply.Id = msg.Id
+	return reply, nil
+}
@@ -0,0 +1,72 @@
+package group
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"mandala/core/protocol"
+)
+
+// Fallback 按顺序使用第一个可用成员，拨号或握手失败时自动切换到下一个
+type Fallback struct {
+	*healthGroup
+}
+
+// NewFallback 创建 fallback 分组并开始后台健康探测
+func NewFallback(tag string, members []Member, testURL string, interval time.Duration) (*Fallback, error) {
+	if len(members) == 0 {
+		return nil, fmt.Errorf("fallback %s has no members", tag)
+	}
+	return &Fallback{healthGroup: newHealthGroup(tag, members, testURL, interval)}, nil
+}
+
+func (g *Fallback) Type() string { return "fallback" }
+
+// Now 返回第一个可用成员
+func (g *Fallback) Now() string {
+	for _, m := range g.members {
+		if g.alive(m.Tag) {
+			return m.Tag
+		}
+	}
+	return g.members[0].Tag
+}
+
+// candidates 返回尝试顺序: 先可用成员，再不可用成员 (全部不可用时仍需尝试)
+func (g *Fallback) candidates() []Member {
+	list := make([]Member, 0, len(g.members))
+	var down []Member
+	for _, m := range g.members {
+		if g.alive(m.Tag) {
+			list = append(list, m)
+		} else {
+			down = append(down, m)
+		}
+	}
+	return append(list, down...)
+}
+
+func (g *Fallback) DialTCP(ctx context.Context, dest protocol.Destination) (net.Conn, error) {
+	return g.dialNetwork(ctx, "tcp", dest)
+}
+
+func (g *Fallback) DialUDP(ctx context.Context, dest protocol.Destination) (net.Conn, error) {
+	return g.dialNetwork(ctx, "udp", dest)
+}
+
+func (g *Fallback) dialNetwork(ctx context.Context, network string, dest protocol.Destination) (net.Conn, error) {
+	var lastErr error
+	for _, m := range g.candidates() {
+		conn, err := g.dial(ctx, m, network, dest)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, fmt.Errorf("fallback %s: all members failed: %v", g.tag, lastErr)
+}
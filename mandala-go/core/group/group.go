@@ -0,0 +1,27 @@
+package group
+
+import (
+	"mandala/core/protocol"
+)
+
+// Group 是由多个成员出站组成的分组出站
+// 每次 Dial 时才决定使用哪个成员，因此切换成员无需重建网络栈
+type Group interface {
+	protocol.Outbound
+	Tag() string
+	Type() string
+	Members() []string
+	Now() string // 当前使用的成员标签
+}
+
+// Member 是分组中的单个成员
+type Member struct {
+	Tag      string
+	Outbound protocol.Outbound
+}
+
+// LatencyReporter 由会主动测速的分组实现
+type LatencyReporter interface {
+	// Latencies 返回 成员标签 -> 最近一次延迟毫秒 (失败为 -1)
+	Latencies() map[string]int
+}
@@ -0,0 +1,215 @@
+package group
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mandala/core/protocol"
+)
+
+const (
+	// healthMaxFailures 连续失败达到该次数后成员被视为不可用
+	healthMaxFailures = 2
+	// healthRetryAfter 不可用成员经过该时间后允许真实流量再次尝试
+	healthRetryAfter = 30 * time.Second
+)
+
+// memberHealth 单个成员的健康状态
+type memberHealth struct {
+	failures    int
+	lastFailure time.Time
+	delay       int // 最近一次探测延迟毫秒，失败为 -1，未探测为 0
+}
+
+// healthGroup 是 fallback / loadbalance 的公共部分:
+// 根据真实连接结果与定期探测维护成员的健康状态
+type healthGroup struct {
+	tag      string
+	members  []Member
+	url      string
+	interval time.Duration
+
+	mu     sync.RWMutex
+	health map[string]*memberHealth
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newHealthGroup(tag string, members []Member, testURL string, interval time.Duration) *healthGroup {
+	if testURL == "" {
+		testURL = DefaultTestURL
+	}
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	g := &healthGroup{
+		tag:      tag,
+		members:  members,
+		url:      testURL,
+		interval: interval,
+		health:   make(map[string]*memberHealth, len(members)),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	for _, m := range members {
+		g.health[m.Tag] = &memberHealth{}
+	}
+	go g.probeLoop()
+	return g
+}
+
+func (g *healthGroup) Tag() string { return g.tag }
+
+func (g *healthGroup) Members() []string {
+	tags := make([]string, len(g.members))
+	for i, m := range g.members {
+		tags[i] = m.Tag
+	}
+	return tags
+}
+
+// Latencies 返回各成员最近一次探测结果
+func (g *healthGroup) Latencies() map[string]int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	out := make(map[string]int, len(g.health))
+	for tag, h := range g.health {
+		if h.delay != 0 {
+			out[tag] = h.delay
+		}
+	}
+	return out
+}
+
+// Close 停止后台探测
+func (g *healthGroup) Close() error {
+	g.cancel()
+	return nil
+}
+
+func (g *healthGroup) probeLoop() {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+	for {
+		results := probeAll(g.ctx, g.members, g.url)
+		if g.ctx.Err() != nil {
+			return
+		}
+		for tag, d := range results {
+			g.report(tag, d >= 0)
+			g.mu.Lock()
+			g.health[tag].delay = d
+			g.mu.Unlock()
+		}
+
+		select {
+		case <-g.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// report 记录成员的一次连接结果
+func (g *healthGroup) report(tag string, ok bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	h := g.health[tag]
+	if h == nil {
+		return
+	}
+	if ok {
+		if h.failures >= healthMaxFailures {
+			log.Printf("[Group] %s 成员恢复: %s", g.tag, tag)
+		}
+		h.failures = 0
+		return
+	}
+	h.failures++
+	h.lastFailure = time.Now()
+	if h.failures == healthMaxFailures {
+		log.Printf("[Group] %s 成员不可用: %s", g.tag, tag)
+	}
+}
+
+// alive 判断成员当前是否可用
+func (g *healthGroup) alive(tag string) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	h := g.health[tag]
+	return h == nil || h.failures < healthMaxFailures || time.Since(h.lastFailure) > healthRetryAfter
+}
+
+// dial 通过指定成员建立 TCP 或 UDP 连接，并记录结果
+func (g *healthGroup) dial(ctx context.Context, m Member, network string, dest protocol.Destination) (net.Conn, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+	if network == "udp" {
+		conn, err = m.Outbound.DialUDP(ctx, dest)
+	} else {
+		conn, err = m.Outbound.DialTCP(ctx, dest)
+	}
+	if err != nil {
+		// 调用方主动取消不计为成员故障
+		if ctx.Err() == nil {
+			g.report(m.Tag, false)
+		}
+		return nil, err
+	}
+	return &trackedConn{Conn: conn, group: g, tag: m.Tag}, nil
+}
+
+// trackedConn 根据连接的首次读取结果上报成员健康状态:
+// 收到远端数据视为成功；已发送数据但远端在返回任何内容前关闭或出错 (握手被拒、节点失效) 视为失败。
+// 本地主动关闭、读取超时以及尚未发送数据的连接不计入结果
+type trackedConn struct {
+	net.Conn
+	group  *healthGroup
+	tag    string
+	once   sync.Once
+	sent   int32 // 已写入数据 (原子访问)
+	closed int32 // 本地已关闭 (原子访问)
+}
+
+func (c *trackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.reportResult(true)
+	} else if err != nil && atomic.LoadInt32(&c.sent) == 1 && atomic.LoadInt32(&c.closed) == 0 && !isTimeout(err) {
+		c.reportResult(false)
+	}
+	return n, err
+}
+
+func (c *trackedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		atomic.StoreInt32(&c.sent, 1)
+	}
+	return n, err
+}
+
+func (c *trackedConn) Close() error {
+	// 先标记再关闭，使读取方看到的关闭错误不被计为失败
+	atomic.StoreInt32(&c.closed, 1)
+	return c.Conn.Close()
+}
+
+func (c *trackedConn) reportResult(ok bool) {
+	c.once.Do(func() {
+		c.group.report(c.tag, ok)
+	})
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
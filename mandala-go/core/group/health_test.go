@@ -0,0 +1,100 @@
+package group
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"mandala/core/protocol"
+)
+
+// pipeOutbound 每次拨号返回 net.Pipe 的一端，另一端交给测试充当远端
+type pipeOutbound struct {
+	remote chan net.Conn
+}
+
+func (o *pipeOutbound) DialTCP(ctx context.Context, dest protocol.Destination) (net.Conn, error) {
+	local, remote := net.Pipe()
+	o.remote <- remote
+	return local, nil
+}
+
+func (o *pipeOutbound) DialUDP(ctx context.Context, dest protocol.Destination) (net.Conn, error) {
+	return o.DialTCP(ctx, dest)
+}
+
+// newTestHealthGroup 创建不启动后台探测的分组，结果只来自真实连接
+func newTestHealthGroup() (*healthGroup, *pipeOutbound) {
+	out := &pipeOutbound{remote: make(chan net.Conn, 1)}
+	g := &healthGroup{
+		tag:     "test",
+		members: []Member{{Tag: "a", Outbound: out}},
+		health:  map[string]*memberHealth{"a": {}},
+	}
+	return g, out
+}
+
+// exchange 拨号并发送请求，remote 决定远端的行为，返回本地读取的结果
+func exchange(t *testing.T, g *healthGroup, out *pipeOutbound, remote func(net.Conn), local func(net.Conn)) {
+	t.Helper()
+	conn, err := g.dial(context.Background(), g.members[0], "tcp", protocol.Destination{Host: "example.com", Port: 443})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := <-out.remote
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 5)
+		io.ReadFull(peer, buf)
+		remote(peer)
+	}()
+	conn.Write([]byte("hello"))
+	local(conn)
+	conn.Close()
+	<-done
+	peer.Close()
+}
+
+func readAll(c net.Conn) { io.Copy(io.Discard, c) }
+
+func TestHealthRemoteCloseWithoutReplyFails(t *testing.T) {
+	g, out := newTestHealthGroup()
+	for i := 0; i < healthMaxFailures; i++ {
+		exchange(t, g, out, func(c net.Conn) { c.Close() }, readAll)
+	}
+	if g.alive("a") {
+		t.Fatal("member still alive after remote closed without reply")
+	}
+
+	exchange(t, g, out, func(c net.Conn) { c.Write([]byte("ok")); c.Close() }, readAll)
+	if !g.alive("a") {
+		t.Fatal("member not recovered after reply")
+	}
+}
+
+func TestHealthLocalCloseIsNotFailure(t *testing.T) {
+	g, out := newTestHealthGroup()
+	for i := 0; i < healthMaxFailures*2; i++ {
+		// 客户端在回复前中止: 本地关闭使远端读取出错
+		started := make(chan struct{})
+		exchange(t, g, out, func(c net.Conn) { <-started; readAll(c) }, func(c net.Conn) {
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				c.Close()
+			}()
+			close(started)
+			readAll(c)
+		})
+	}
+	// 读取超时 (如服务端先沉默) 同样不计为失败
+	exchange(t, g, out, func(c net.Conn) { readAll(c) }, func(c net.Conn) {
+		c.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+		c.Read(make([]byte, 1))
+	})
+	if g.health["a"].failures != 0 {
+		t.Fatalf("failures = %d, want 0", g.health["a"].failures)
+	}
+}
@@ -0,0 +1,122 @@
+package group
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"mandala/core/protocol"
+)
+
+// 负载均衡策略
+const (
+	StrategyConsistentHashing = "consistent-hashing"
+	StrategyRoundRobin        = "round-robin"
+)
+
+// LoadBalance 在可用成员之间分配连接
+//   - consistent-hashing: 按目标主机做一致性哈希 (rendezvous)，同一目标固定走同一成员，
+//     成员上下线时只影响原本落在该成员上的目标
+//   - round-robin: 依次轮换
+type LoadBalance struct {
+	*healthGroup
+	strategy string
+	next     uint32
+}
+
+// NewLoadBalance 创建 loadbalance 分组并开始后台健康探测
+func NewLoadBalance(tag string, members []Member, strategy, testURL string, interval time.Duration) (*LoadBalance, error) {
+	if len(members) == 0 {
+		return nil, fmt.Errorf("loadbalance %s has no members", tag)
+	}
+	switch strings.ToLower(strategy) {
+	case "", StrategyConsistentHashing:
+		strategy = StrategyConsistentHashing
+	case StrategyRoundRobin:
+		strategy = StrategyRoundRobin
+	default:
+		return nil, fmt.Errorf("loadbalance %s: unknown strategy: %s", tag, strategy)
+	}
+	return &LoadBalance{
+		healthGroup: newHealthGroup(tag, members, testURL, interval),
+		strategy:    strategy,
+	}, nil
+}
+
+func (g *LoadBalance) Type() string { return "loadbalance" }
+
+// Now 返回策略名称，负载均衡没有固定的当前成员
+func (g *LoadBalance) Now() string {
+	return g.strategy
+}
+
+func (g *LoadBalance) DialTCP(ctx context.Context, dest protocol.Destination) (net.Conn, error) {
+	return g.dialNetwork(ctx, "tcp", dest)
+}
+
+func (g *LoadBalance) DialUDP(ctx context.Context, dest protocol.Destination) (net.Conn, error) {
+	return g.dialNetwork(ctx, "udp", dest)
+}
+
+// dialNetwork 选择成员拨号，失败时排除该成员后重新选择
+func (g *LoadBalance) dialNetwork(ctx context.Context, network string, dest protocol.Destination) (net.Conn, error) {
+	tried := make(map[string]bool, len(g.members))
+	var lastErr error
+	for len(tried) < len(g.members) {
+		m := g.pick(dest, tried)
+		tried[m.Tag] = true
+		conn, err := g.dial(ctx, m, network, dest)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, fmt.Errorf("loadbalance %s: all members failed: %v", g.tag, lastErr)
+}
+
+// pick 在未尝试过的成员中选择一个，优先可用成员
+func (g *LoadBalance) pick(dest protocol.Destination, exclude map[string]bool) Member {
+	var alive, down []Member
+	for _, m := range g.members {
+		if exclude[m.Tag] {
+			continue
+		}
+		if g.alive(m.Tag) {
+			alive = append(alive, m)
+		} else {
+			down = append(down, m)
+		}
+	}
+	candidates := alive
+	if len(candidates) == 0 {
+		candidates = down
+	}
+
+	if g.strategy == StrategyRoundRobin {
+		n := atomic.AddUint32(&g.next, 1)
+		return candidates[(n-1)%uint32(len(candidates))]
+	}
+
+	// rendezvous hashing: 选择 hash(目标, 成员) 最大的成员
+	var (
+		best      Member
+		bestScore uint64
+	)
+	for i, m := range candidates {
+		h := fnv.New64a()
+		h.Write([]byte(dest.Host))
+		h.Write([]byte{0})
+		h.Write([]byte(m.Tag))
+		if score := h.Sum64(); i == 0 || score > bestScore {
+			best, bestScore = m, score
+		}
+	}
+	return best
+}
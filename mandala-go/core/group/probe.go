@@ -0,0 +1,110 @@
+package group
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"mandala/core/protocol"
+)
+
+// DefaultTestURL 默认测速地址
+const DefaultTestURL = "https://www.gstatic.com/generate_204"
+
+// probeTimeout 单次测速的超时时间
+const probeTimeout = 5 * time.Second
+
+// URLTestDelay 通过 ob 完整地建立连接 (拨号 + 协议握手) 并请求 rawURL，返回从拨号到收到响应头的耗时
+func URLTestDelay(ctx context.Context, ob protocol.Outbound, rawURL string) (time.Duration, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return 0, err
+	}
+	host := u.Hostname()
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return 0, fmt.Errorf("invalid test url: %s", rawURL)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+
+	start := time.Now()
+	conn, err := ob.DialTCP(ctx, protocol.Destination{Host: host, Port: portNum})
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+
+	if u.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return 0, err
+		}
+		conn = tlsConn
+	}
+
+	req, err := http.NewRequest(http.MethodHead, u.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Close = true
+	if err := req.Write(conn); err != nil {
+		return 0, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return time.Since(start), nil
+}
+
+// delayMillis 将测速结果转换为毫秒，失败记为 -1
+func delayMillis(d time.Duration, err error) int {
+	if err != nil {
+		return -1
+	}
+	ms := int(d / time.Millisecond)
+	if ms == 0 {
+		ms = 1
+	}
+	return ms
+}
+
+type probeResult struct {
+	tag   string
+	delay int
+}
+
+// probeAll 并发测试全部成员，返回 成员标签 -> 延迟毫秒 (失败为 -1)
+func probeAll(ctx context.Context, members []Member, testURL string) map[string]int {
+	ch := make(chan probeResult, len(members))
+	for _, m := range members {
+		go func(m Member) {
+			d, err := URLTestDelay(ctx, m.Outbound, testURL)
+			ch <- probeResult{tag: m.Tag, delay: delayMillis(d, err)}
+		}(m)
+	}
+
+	results := make(map[string]int, len(members))
+	for range members {
+		r := <-ch
+		results[r.tag] = r.delay
+	}
+	return results
+}
@@ -0,0 +1,90 @@
+package group
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+
+	"mandala/core/protocol"
+)
+
+// Selector 手动选择成员的分组
+type Selector struct {
+	tag     string
+	members []Member
+
+	mu       sync.RWMutex
+	selected int
+}
+
+// NewSelector 创建 selector 分组，def 为初始选中的成员标签 (为空或不存在时选第一个)
+func NewSelector(tag string, members []Member, def string) (*Selector, error) {
+	if len(members) == 0 {
+		return nil, fmt.Errorf("selector %s has no members", tag)
+	}
+	s := &Selector{tag: tag, members: members}
+	for i, m := range members {
+		if m.Tag == def {
+			s.selected = i
+			break
+		}
+	}
+	return s, nil
+}
+
+func (s *Selector) Tag() string  { return s.tag }
+func (s *Selector) Type() string { return "selector" }
+
+func (s *Selector) Members() []string {
+	tags := make([]string, len(s.members))
+	for i, m := range s.members {
+		tags[i] = m.Tag
+	}
+	return tags
+}
+
+func (s *Selector) Now() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.members[s.selected].Tag
+}
+
+// Has 判断 tag 是否为该分组的成员
+func (s *Selector) Has(tag string) bool {
+	for _, m := range s.members {
+		if m.Tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Select 切换当前成员，只影响之后新建的连接
+func (s *Selector) Select(tag string) error {
+	for i, m := range s.members {
+		if m.Tag == tag {
+			s.mu.Lock()
+			s.selected = i
+			s.mu.Unlock()
+			log.Printf("[Group] %s 切换到: %s", s.tag, tag)
+			return nil
+		}
+	}
+	return fmt.Errorf("outbound %s is not a member of %s", tag, s.tag)
+}
+
+func (s *Selector) current() protocol.Outbound {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.members[s.selected].Outbound
+}
+
+func (s *Selector) DialTCP(ctx context.Context, dest protocol.Destination) (net.Conn, error) {
+	return s.current().DialTCP(ctx, dest)
+}
+
+func (s *Selector) DialUDP(ctx context.Context, dest protocol.Destination) (net.Conn, error) {
+	return s.current().DialUDP(ctx, dest)
+}
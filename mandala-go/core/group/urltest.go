@@ -0,0 +1,157 @@
+package group
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"mandala/core/protocol"
+)
+
+// URLTest 定期测速并自动选择延迟最低成员的分组
+type URLTest struct {
+	tag       string
+	members   []Member
+	url       string
+	interval  time.Duration
+	tolerance time.Duration
+
+	mu       sync.RWMutex
+	selected int
+	delays   map[string]int // 成员标签 -> 最近一次延迟毫秒，失败为 -1
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewURLTest 创建 urltest 分组并立即开始后台测速
+func NewURLTest(tag string, members []Member, testURL string, interval, tolerance time.Duration) (*URLTest, error) {
+	if len(members) == 0 {
+		return nil, fmt.Errorf("urltest %s has no members", tag)
+	}
+	if testURL == "" {
+		testURL = DefaultTestURL
+	}
+	if interval <= 0 {
+		interval = 3 * time.Minute
+	}
+	if tolerance <= 0 {
+		tolerance = 50 * time.Millisecond
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g := &URLTest{
+		tag:       tag,
+		members:   members,
+		url:       testURL,
+		interval:  interval,
+		tolerance: tolerance,
+		delays:    make(map[string]int),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	go g.loop()
+	return g, nil
+}
+
+func (g *URLTest) Tag() string  { return g.tag }
+func (g *URLTest) Type() string { return "urltest" }
+
+func (g *URLTest) Members() []string {
+	tags := make([]string, len(g.members))
+	for i, m := range g.members {
+		tags[i] = m.Tag
+	}
+	return tags
+}
+
+func (g *URLTest) Now() string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.members[g.selected].Tag
+}
+
+// Latencies 返回各成员最近一次测速结果 (毫秒，失败为 -1，未测试的成员不包含在内)
+func (g *URLTest) Latencies() map[string]int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	out := make(map[string]int, len(g.delays))
+	for k, v := range g.delays {
+		out[k] = v
+	}
+	return out
+}
+
+// Close 停止后台测速
+func (g *URLTest) Close() error {
+	g.cancel()
+	return nil
+}
+
+func (g *URLTest) loop() {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+	for {
+		g.CheckNow()
+		select {
+		case <-g.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckNow 立即测试全部成员并按结果重新选择
+func (g *URLTest) CheckNow() {
+	results := probeAll(g.ctx, g.members, g.url)
+	if g.ctx.Err() != nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.delays = results
+
+	best := -1
+	for i, m := range g.members {
+		d := results[m.Tag]
+		if d < 0 {
+			continue
+		}
+		if best < 0 || d < results[g.members[best].Tag] {
+			best = i
+		}
+	}
+	if best < 0 {
+		log.Printf("[Group] %s 全部成员测速失败，保持: %s", g.tag, g.members[g.selected].Tag)
+		return
+	}
+
+	// 当前成员仍可用且与最优成员的差距在容差内时不切换，避免频繁抖动
+	cur := results[g.members[g.selected].Tag]
+	tol := int(g.tolerance / time.Millisecond)
+	if cur >= 0 && cur <= results[g.members[best].Tag]+tol {
+		return
+	}
+	if best != g.selected {
+		log.Printf("[Group] %s 切换到: %s (%dms)", g.tag, g.members[best].Tag, results[g.members[best].Tag])
+		g.selected = best
+	}
+}
+
+func (g *URLTest) current() protocol.Outbound {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.members[g.selected].Outbound
+}
+
+func (g *URLTest) DialTCP(ctx context.Context, dest protocol.Destination) (net.Conn, error) {
+	return g.current().DialTCP(ctx, dest)
+}
+
+func (g *URLTest) DialUDP(ctx context.Context, dest protocol.Destination) (net.Conn, error) {
+	return g.current().DialUDP(ctx, dest)
+}
@@ -0,0 +1,146 @@
+//go:build linux
+
+// Package protecttest 提供套接字保护相关测试共用的假 VpnService.protect 与 DNS 服务器
+package protecttest
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// Recorder 是记录所有被保护套接字的假 VpnService.protect
+type Recorder struct {
+	mu    sync.Mutex
+	inos  map[uint64]bool
+	types []int // 各套接字的 SO_TYPE
+}
+
+// Install 创建 Recorder 并通过 set (protocol.SetSocketProtector) 安装，测试结束时移除
+func Install(t testing.TB, set func(protect func(fd int) bool)) *Recorder {
+	r := &Recorder{inos: make(map[uint64]bool)}
+	set(r.Protect)
+	t.Cleanup(func() { set(nil) })
+	return r
+}
+
+// Protect 记录 fd 对应的套接字并返回成功
+func (r *Recorder) Protect(fd int) bool {
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return false
+	}
+	typ, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TYPE)
+	if err != nil {
+		return false
+	}
+	r.mu.Lock()
+	r.inos[st.Ino] = true
+	r.types = append(r.types, typ)
+	r.mu.Unlock()
+	return true
+}
+
+// Count 返回已保护的指定类型 (syscall.SOCK_STREAM / SOCK_DGRAM) 套接字数
+func (r *Recorder) Count(typ int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, t := range r.types {
+		if t == typ {
+			n++
+		}
+	}
+	return n
+}
+
+// Protected 判断本进程中本地地址为 addr 的套接字是否经过保护
+// 按本地端口与套接字类型在 /proc/self/fd 中查找，须在连接仍打开时调用
+func (r *Recorder) Protected(addr net.Addr) bool {
+	_, portStr, _ := net.SplitHostPort(addr.String())
+	port, _ := strconv.Atoi(portStr)
+	sotype := syscall.SOCK_STREAM
+	if addr.Network() == "udp" {
+		sotype = syscall.SOCK_DGRAM
+	}
+
+	entries, _ := os.ReadDir("/proc/self/fd")
+	for _, e := range entries {
+		fd, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		if typ, err := syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_TYPE); err != nil || typ != sotype {
+			continue
+		}
+		sa, err := syscall.Getsockname(fd)
+		if err != nil {
+			continue
+		}
+		var local int
+		switch sa := sa.(type) {
+		case *syscall.SockaddrInet4:
+			local = sa.Port
+		case *syscall.SockaddrInet6:
+			local = sa.Port
+		}
+		if local != port {
+			continue
+		}
+		var st syscall.Stat_t
+		if syscall.Fstat(fd, &st) != nil {
+			continue
+		}
+		r.mu.Lock()
+		ok := r.inos[st.Ino]
+		r.mu.Unlock()
+		return ok
+	}
+	return false
+}
+
+// StartDNS 启动只应答 A 记录的 UDP DNS 服务器，并通过 use (protocol.SetResolverServers) 设为核心解析器使用的服务器
+// records 为 FQDN -> IP，其余域名返回 NXDOMAIN；onQuery 不为 nil 时在应答前以查询来源地址调用 (此时客户端套接字仍打开)
+func StartDNS(t testing.TB, use func(servers []string), records map[string]string, onQuery func(peer net.Addr)) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req := new(dns.Msg)
+			if req.Unpack(buf[:n]) != nil || len(req.Question) == 0 {
+				continue
+			}
+			if onQuery != nil {
+				onQuery(addr)
+			}
+			resp := new(dns.Msg)
+			resp.SetReply(req)
+			q := req.Question[0]
+			if ip, ok := records[q.Name]; !ok {
+				resp.Rcode = dns.RcodeNameError
+			} else if q.Qtype == dns.TypeA {
+				resp.Answer = append(resp.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+					A:   net.ParseIP(ip),
+				})
+			}
+			out, _ := resp.Pack()
+			pc.WriteTo(out, addr)
+		}
+	}()
+	use([]string{pc.LocalAddr().String()})
+	t.Cleanup(func() { use(nil) })
+}
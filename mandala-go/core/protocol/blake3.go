@@ -0,0 +1,174 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// ==========================================
+// BLAKE3 精简实现 (仅支持 32 字节输出)
+// Shadowsocks 2022 需要 BLAKE3 的 hash 与 derive_key 两种模式
+// ==========================================
+
+const (
+	blake3ChunkLen  = 1024
+	blake3BlockLen  = 64
+	blake3OutLen    = 32
+	flagChunkStart  = 1 << 0
+	flagChunkEnd    = 1 << 1
+	flagParent      = 1 << 2
+	flagRoot        = 1 << 3
+	flagDeriveKeyCt = 1 << 5
+	flagDeriveKeyMt = 1 << 6
+)
+
+var blake3IV = [8]uint32{
+	0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A,
+	0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19,
+}
+
+var blake3MsgPermutation = [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+
+func blake3G(s *[16]uint32, a, b, c, d int, mx, my uint32) {
+	s[a] = s[a] + s[b] + mx
+	s[d] = bits.RotateLeft32(s[d]^s[a], -16)
+	s[c] = s[c] + s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -12)
+	s[a] = s[a] + s[b] + my
+	s[d] = bits.RotateLeft32(s[d]^s[a], -8)
+	s[c] = s[c] + s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -7)
+}
+
+func blake3Compress(cv [8]uint32, block [16]uint32, counter uint64, blockLen uint32, flags uint32) [16]uint32 {
+	s := [16]uint32{
+		cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7],
+		blake3IV[0], blake3IV[1], blake3IV[2], blake3IV[3],
+		uint32(counter), uint32(counter >> 32), blockLen, flags,
+	}
+	m := block
+	for r := 0; r < 7; r++ {
+		blake3G(&s, 0, 4, 8, 12, m[0], m[1])
+		blake3G(&s, 1, 5, 9, 13, m[2], m[3])
+		blake3G(&s, 2, 6, 10, 14, m[4], m[5])
+		blake3G(&s, 3, 7, 11, 15, m[6], m[7])
+		blake3G(&s, 0, 5, 10, 15, m[8], m[9])
+		blake3G(&s, 1, 6, 11, 12, m[10], m[11])
+		blake3G(&s, 2, 7, 8, 13, m[12], m[13])
+		blake3G(&s, 3, 4, 9, 14, m[14], m[15])
+
+		var permuted [16]uint32
+		for i, j := range blake3MsgPermutation {
+			permuted[i] = m[j]
+		}
+		m = permuted
+	}
+	for i := 0; i < 8; i++ {
+		s[i] ^= s[i+8]
+		s[i+8] ^= cv[i]
+	}
+	return s
+}
+
+func blake3Words(b []byte) [16]uint32 {
+	var padded [blake3BlockLen]byte
+	copy(padded[:], b)
+	var w [16]uint32
+	for i := range w {
+		w[i] = binary.LittleEndian.Uint32(padded[i*4:])
+	}
+	return w
+}
+
+// blake3Output 保存尚未决定是否为根节点的最后一次压缩参数
+type blake3Output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o blake3Output) chainingValue() [8]uint32 {
+	s := blake3Compress(o.cv, o.block, o.counter, o.blockLen, o.flags)
+	var cv [8]uint32
+	copy(cv[:], s[:8])
+	return cv
+}
+
+func (o blake3Output) rootBytes() []byte {
+	s := blake3Compress(o.cv, o.block, 0, o.blockLen, o.flags|flagRoot)
+	out := make([]byte, blake3OutLen)
+	for i := 0; i < 8; i++ {
+		binary.LittleEndian.PutUint32(out[i*4:], s[i])
+	}
+	return out
+}
+
+// blake3ChunkOutput 处理单个 Chunk (最多 1024 字节)，返回其最后一个块的输出
+func blake3ChunkOutput(key [8]uint32, chunk []byte, counter uint64, flags uint32) blake3Output {
+	cv := key
+	blockFlags := uint32(flagChunkStart)
+	for len(chunk) > blake3BlockLen {
+		s := blake3Compress(cv, blake3Words(chunk[:blake3BlockLen]), counter, blake3BlockLen, flags|blockFlags)
+		copy(cv[:], s[:8])
+		chunk = chunk[blake3BlockLen:]
+		blockFlags = 0
+	}
+	return blake3Output{
+		cv:       cv,
+		block:    blake3Words(chunk),
+		counter:  counter,
+		blockLen: uint32(len(chunk)),
+		flags:    flags | blockFlags | flagChunkEnd,
+	}
+}
+
+func blake3ParentOutput(left, right [8]uint32, key [8]uint32, flags uint32) blake3Output {
+	var block [16]uint32
+	copy(block[:8], left[:])
+	copy(block[8:], right[:])
+	return blake3Output{cv: key, block: block, blockLen: blake3BlockLen, flags: flags | flagParent}
+}
+
+// blake3Hash 计算 32 字节 BLAKE3 输出
+func blake3Hash(key [8]uint32, flags uint32, input []byte) []byte {
+	var stack [][8]uint32
+	var chunkCounter uint64
+
+	// 除最后一个 Chunk 外，其余 Chunk 压入 CV 栈并按二叉树合并
+	for len(input) > blake3ChunkLen {
+		cv := blake3ChunkOutput(key, input[:blake3ChunkLen], chunkCounter, flags).chainingValue()
+		input = input[blake3ChunkLen:]
+		chunkCounter++
+		for total := chunkCounter; total&1 == 0; total >>= 1 {
+			left := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			cv = blake3ParentOutput(left, cv, key, flags).chainingValue()
+		}
+		stack = append(stack, cv)
+	}
+
+	out := blake3ChunkOutput(key, input, chunkCounter, flags)
+	for len(stack) > 0 {
+		left := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		out = blake3ParentOutput(left, out.chainingValue(), key, flags)
+	}
+	return out.rootBytes()
+}
+
+// Blake3Sum256 计算标准 BLAKE3 哈希 (32 字节)
+func Blake3Sum256(data []byte) []byte {
+	return blake3Hash(blake3IV, 0, data)
+}
+
+// Blake3DeriveKey 实现 BLAKE3 derive_key 模式，输出长度不超过 32 字节
+func Blake3DeriveKey(context string, material []byte, outLen int) []byte {
+	ctxKey := blake3Hash(blake3IV, flagDeriveKeyCt, []byte(context))
+	var key [8]uint32
+	for i := range key {
+		key[i] = binary.LittleEndian.Uint32(ctxKey[i*4:])
+	}
+	return blake3Hash(key, flagDeriveKeyMt, material)[:outLen]
+}
@@ -0,0 +1,36 @@
+package protocol
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"mandala/core/config"
+)
+
+func init() {
+	RegisterOutbound(func(cfg *config.OutboundConfig, dial DialFunc) (Outbound, error) {
+		return NewDirectOutbound(), nil
+	}, "direct")
+}
+
+// directOutbound 不经过代理，直接连接目标
+// TCP 与 UDP 套接字均在连接前应用 SetSocketProtector / SetSocketBinding 设置的选项，避免 TUN 模式下流量回环；
+// 目标域名同样经由受保护的解析器解析，不会得到 Fake-IP
+type directOutbound struct {
+	dialer *net.Dialer
+}
+
+// NewDirectOutbound 创建直连出站
+func NewDirectOutbound() Outbound {
+	return &directOutbound{dialer: NewProtectedDialer(5 * time.Second)}
+}
+
+func (o *directOutbound) DialTCP(ctx context.Context, dest Destination) (net.Conn, error) {
+	return o.dialer.DialContext(ctx, "tcp", dest.String())
+}
+
+// DialUDP 返回已连接的 UDP socket，每次 Write/Read 即一个数据报
+func (o *directOutbound) DialUDP(ctx context.Context, dest Destination) (net.Conn, error) {
+	return o.dialer.DialContext(ctx, "udp", dest.String())
+}
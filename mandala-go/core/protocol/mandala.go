@@ -10,6 +10,14 @@ import (
 	"io"
 	"log"
 	"net"
+
+	"mandala/core/config"
+)
+
+// Mandala 指令
+const (
+	mandalaCmdConnect = 0x01
+	mandalaCmdUDP     = 0x03
 )
 
 // MandalaClient 处理 Mandala 协议的客户端逻辑
@@ -30,7 +38,17 @@ func NewMandalaClient(username, password string) *MandalaClient {
 // [Update] V2: 引入完整性校验 (Integrity Check)
 // 结构: [Salt(4)] [StreamXOR( SHA256(AuthKey+Header) + Header )]
 func (c *MandalaClient) BuildHandshakePayload(targetHost string, targetPort int, useNoise bool) ([]byte, error) {
-	log.Printf("[Mandala] 开始构造 V2 握手包 -> %s:%d", targetHost, targetPort)
+	return c.buildHandshake(mandalaCmdConnect, targetHost, targetPort, useNoise)
+}
+
+// BuildUDPHandshakePayload 构造 Mandala UDP 会话握手包 (CMD 0x03)
+// 之后的每个数据报与 Trojan 相同，使用 [SOCKS5_ADDR][Length(2)][CRLF][Payload] 封装
+func (c *MandalaClient) BuildUDPHandshakePayload(targetHost string, targetPort int, useNoise bool) ([]byte, error) {
+	return c.buildHandshake(mandalaCmdUDP, targetHost, targetPort, useNoise)
+}
+
+func (c *MandalaClient) buildHandshake(cmd byte, targetHost string, targetPort int, useNoise bool) ([]byte, error) {
+	log.Printf("[Mandala] 开始构造 V2 握手包 -> %s:%d (CMD: 0x%02x)", targetHost, targetPort, cmd)
 
 	// 1. 生成随机 Salt (4 bytes)
 	salt := make([]byte, 4)
@@ -73,9 +91,8 @@ func (c *MandalaClient) BuildHandshakePayload(targetHost string, targetPort int,
 		headerBuf.Write(padding)
 	}
 
-	// 3.2 指令 CMD (0x01 Connect)
-	// 目前仅支持 TCP Connect，UDP 需要根据需求传入参数修改
-	headerBuf.WriteByte(0x01)
+	// 3.2 指令 CMD (0x01 Connect / 0x03 UDP)
+	headerBuf.WriteByte(cmd)
 
 	// 3.3 目标地址 (SOCKS5 格式)
 	ip := net.ParseIP(targetHost)
@@ -140,3 +157,29 @@ func (c *MandalaClient) BuildHandshakePayload(targetHost string, targetPort int,
 	// log.Printf("[Mandala] V2 握手包构造完成，总长度: %d", finalSize)
 	return finalBuf, nil
 }
+
+func init() {
+	RegisterOutbound(func(cfg *config.OutboundConfig, dial DialFunc) (Outbound, error) {
+		client := NewMandalaClient(cfg.Username, cfg.Password)
+		return &streamOutbound{
+			dial: dial,
+			handshake: func(conn net.Conn, dest Destination) (net.Conn, error) {
+				payload, err := client.BuildHandshakePayload(dest.Host, dest.Port, cfg.Settings.Noise)
+				if err != nil {
+					return nil, err
+				}
+				return writePayload(conn, payload)
+			},
+			udpHandshake: func(conn net.Conn, dest Destination) (net.Conn, error) {
+				payload, err := client.BuildUDPHandshakePayload(dest.Host, dest.Port, cfg.Settings.Noise)
+				if err != nil {
+					return nil, err
+				}
+				if _, err := writePayload(conn, payload); err != nil {
+					return nil, err
+				}
+				return NewAddrPacketConn(conn, dest), nil
+			},
+		}, nil
+	}, "mandala")
+}
@@ -0,0 +1,105 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"mandala/core/config"
+)
+
+// Destination 描述代理的目标地址 (域名或 IP)
+type Destination struct {
+	Host string
+	Port int
+}
+
+func (d Destination) String() string {
+	return net.JoinHostPort(d.Host, strconv.Itoa(d.Port))
+}
+
+// DialFunc 建立到代理服务器的底层连接 (TCP / TLS / WebSocket)
+type DialFunc func(ctx context.Context) (net.Conn, error)
+
+// Outbound 是所有出站协议的统一接口
+// SOCKS 入站、TUN TCP、TUN UDP 与 DNS 均通过它建立到目标的连接
+type Outbound interface {
+	// DialTCP 返回一条到 dest 的已完成握手的流式连接
+	DialTCP(ctx context.Context, dest Destination) (net.Conn, error)
+	// DialUDP 返回一条到 dest 的 UDP 会话，每次 Write 发送一个数据报
+	DialUDP(ctx context.Context, dest Destination) (net.Conn, error)
+}
+
+// OutboundFactory 根据节点配置与底层拨号函数创建 Outbound
+type OutboundFactory func(cfg *config.OutboundConfig, dial DialFunc) (Outbound, error)
+
+var (
+	outboundRegistry = make(map[string]OutboundFactory)
+	registryMu       sync.RWMutex
+)
+
+// RegisterOutbound 注册一种出站协议，types 为该协议在配置中的类型名 (可多个别名)
+func RegisterOutbound(factory OutboundFactory, types ...string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, t := range types {
+		outboundRegistry[strings.ToLower(t)] = factory
+	}
+}
+
+// NewOutbound 按 cfg.Type 从注册表创建出站实例
+func NewOutbound(cfg *config.OutboundConfig, dial DialFunc) (Outbound, error) {
+	registryMu.RLock()
+	factory, ok := outboundRegistry[strings.ToLower(cfg.Type)]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("protocol not implemented: %s", cfg.Type)
+	}
+	return factory(cfg, dial)
+}
+
+// HandshakeFunc 在已建立的底层连接上执行协议握手，返回用于数据传输的连接
+type HandshakeFunc func(conn net.Conn, dest Destination) (net.Conn, error)
+
+// streamOutbound 是基于单条流式连接的通用出站实现：
+// 先通过 dial 建立底层连接，再执行协议握手
+type streamOutbound struct {
+	dial         DialFunc
+	handshake    HandshakeFunc
+	udpHandshake HandshakeFunc // 为 nil 时 UDP 复用 TCP 握手
+}
+
+func (o *streamOutbound) DialTCP(ctx context.Context, dest Destination) (net.Conn, error) {
+	return o.dialWith(ctx, dest, o.handshake)
+}
+
+func (o *streamOutbound) DialUDP(ctx context.Context, dest Destination) (net.Conn, error) {
+	if o.udpHandshake != nil {
+		return o.dialWith(ctx, dest, o.udpHandshake)
+	}
+	return o.dialWith(ctx, dest, o.handshake)
+}
+
+func (o *streamOutbound) dialWith(ctx context.Context, dest Destination, handshake HandshakeFunc) (net.Conn, error) {
+	conn, err := o.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := handshake(conn, dest)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return pc, nil
+}
+
+// writePayload 将预构造的握手包写入连接，供只需单次写入的协议复用
+func writePayload(conn net.Conn, payload []byte) (net.Conn, error) {
+	if _, err := conn.Write(payload); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
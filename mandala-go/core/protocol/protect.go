@@ -0,0 +1,107 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// SocketOptions 出站套接字 (直连、代理服务器、DoH 等) 在连接前应用的选项，使其流量不再进入 TUN
+type SocketOptions struct {
+	// Protect 对套接字执行保护 (Android 上对应 VpnService.protect)，返回 false 表示失败
+	Protect func(fd int) bool
+	// Mark 设置 SO_MARK 路由标记 (仅 Linux，需 CAP_NET_ADMIN)，0 表示不设置
+	Mark int
+	// Interface 通过 SO_BINDTODEVICE 绑定出口网卡 (仅 Linux)，为空表示不绑定
+	Interface string
+}
+
+var (
+	socketOptions   SocketOptions
+	socketOptionsMu sync.RWMutex
+
+	resolverServers   []string // 为空时使用系统配置 (/etc/resolv.conf) 中的服务器
+	resolverServersMu sync.RWMutex
+	resolverNext      uint32
+)
+
+// protectedResolver 解析代理服务器与直连目标的域名
+// 使用 Go 内置解析器且查询套接字同样受保护: 系统解析器的查询会进入 TUN，Fake-IP 模式下将得到虚假地址
+var protectedResolver = &net.Resolver{PreferGo: true, Dial: dialResolver}
+
+// SetSocketProtector 设置套接字保护回调，传入 nil 取消；对之后新建的套接字生效
+func SetSocketProtector(protect func(fd int) bool) {
+	socketOptionsMu.Lock()
+	socketOptions.Protect = protect
+	socketOptionsMu.Unlock()
+}
+
+// SetSocketBinding 设置路由标记与绑定网卡，均为零值表示不设置
+func SetSocketBinding(mark int, iface string) {
+	socketOptionsMu.Lock()
+	socketOptions.Mark = mark
+	socketOptions.Interface = iface
+	socketOptionsMu.Unlock()
+}
+
+// SetResolverServers 设置核心自身解析域名使用的 DNS 服务器 ("1.1.1.1" 或 "1.1.1.1:53")，传入空切片恢复系统配置
+// Android 上没有可用的 /etc/resolv.conf，应传入底层网络的 DNS 服务器
+func SetResolverServers(servers []string) {
+	var list []string
+	for _, s := range servers {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			s = net.JoinHostPort(s, "53")
+		}
+		list = append(list, s)
+	}
+	resolverServersMu.Lock()
+	resolverServers = list
+	resolverServersMu.Unlock()
+}
+
+// NewProtectedDialer 创建在连接前应用全局套接字选项的 Dialer，目标域名经由受保护的解析器解析
+// 所有由核心主动发起的出站连接都应经由它建立，否则 App 自身流量进入 TUN 时会形成回环
+func NewProtectedDialer(timeout time.Duration) *net.Dialer {
+	return &net.Dialer{Timeout: timeout, Control: protectControl, Resolver: protectedResolver}
+}
+
+// dialResolver 建立受保护的 DNS 查询连接；设置了服务器时替换系统配置的地址，每次拨号轮换以便失败重试时切换
+func dialResolver(ctx context.Context, network, address string) (net.Conn, error) {
+	resolverServersMu.RLock()
+	if len(resolverServers) > 0 {
+		address = resolverServers[int(atomic.AddUint32(&resolverNext, 1)-1)%len(resolverServers)]
+	}
+	resolverServersMu.RUnlock()
+	d := net.Dialer{Control: protectControl}
+	return d.DialContext(ctx, network, address)
+}
+
+// protectControl 作为 net.Dialer.Control 在 connect 之前对套接字应用选项
+func protectControl(network, address string, c syscall.RawConn) error {
+	socketOptionsMu.RLock()
+	opts := socketOptions
+	socketOptionsMu.RUnlock()
+	if opts.Protect == nil && opts.Mark == 0 && opts.Interface == "" {
+		return nil
+	}
+
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		if opts.Protect != nil && !opts.Protect(int(fd)) {
+			err = fmt.Errorf("protect socket failed")
+			return
+		}
+		err = applySocketOptions(fd, opts)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
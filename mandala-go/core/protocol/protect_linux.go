@@ -0,0 +1,21 @@
+package protocol
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// applySocketOptions 设置 SO_MARK 与 SO_BINDTODEVICE (android 构建同样适用)
+func applySocketOptions(fd uintptr, opts SocketOptions) error {
+	if opts.Mark != 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, opts.Mark); err != nil {
+			return fmt.Errorf("set SO_MARK failed: %v", err)
+		}
+	}
+	if opts.Interface != "" {
+		if err := syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, opts.Interface); err != nil {
+			return fmt.Errorf("set SO_BINDTODEVICE failed: %v", err)
+		}
+	}
+	return nil
+}
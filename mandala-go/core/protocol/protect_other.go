@@ -0,0 +1,13 @@
+//go:build !linux
+
+package protocol
+
+import "fmt"
+
+// applySocketOptions 非 Linux 平台不支持路由标记与绑定网卡
+func applySocketOptions(fd uintptr, opts SocketOptions) error {
+	if opts.Mark != 0 || opts.Interface != "" {
+		return fmt.Errorf("routing mark and interface binding are only supported on linux")
+	}
+	return nil
+}
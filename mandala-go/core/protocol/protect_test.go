@@ -0,0 +1,83 @@
+//go:build linux
+
+package protocol
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"mandala/core/internal/protecttest"
+)
+
+func TestDirectDialDomainUsesProtectedResolver(t *testing.T) {
+	rec := protecttest.Install(t, SetSocketProtector)
+	protecttest.StartDNS(t, SetResolverServers, map[string]string{"direct.test.": "127.0.0.1"}, nil)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			c.Write([]byte("ok"))
+			c.Close()
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	conn, err := NewDirectOutbound().DialTCP(context.Background(), Destination{Host: "direct.test", Port: port})
+	if err != nil {
+		t.Fatalf("dial tcp: %v", err)
+	}
+	buf := make([]byte, 2)
+	if _, err := conn.Read(buf); err != nil || string(buf) != "ok" {
+		t.Fatalf("read: %q %v", buf, err)
+	}
+	conn.Close()
+
+	if n := rec.Count(syscall.SOCK_DGRAM); n == 0 {
+		t.Fatal("dns query socket was not protected")
+	}
+	if n := rec.Count(syscall.SOCK_STREAM); n != 1 {
+		t.Fatalf("protected tcp sockets = %d, want 1", n)
+	}
+}
+
+func TestDirectDialUDPDomainUsesProtectedResolver(t *testing.T) {
+	rec := protecttest.Install(t, SetSocketProtector)
+	protecttest.StartDNS(t, SetResolverServers, map[string]string{"direct.test.": "127.0.0.1"}, nil)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+
+	conn, err := NewDirectOutbound().DialUDP(context.Background(), Destination{Host: "direct.test", Port: port})
+	if err != nil {
+		t.Fatalf("dial udp: %v", err)
+	}
+	defer conn.Close()
+	if got := conn.RemoteAddr().String(); got != net.JoinHostPort("127.0.0.1", strconv.Itoa(port)) {
+		t.Fatalf("remote = %s", got)
+	}
+	// 至少一个 DNS 查询套接字加上数据套接字
+	if n := rec.Count(syscall.SOCK_DGRAM); n < 2 {
+		t.Fatalf("protected udp sockets = %d, want >= 2", n)
+	}
+}
+
+func TestDirectDialDomainNotResolved(t *testing.T) {
+	protecttest.Install(t, SetSocketProtector)
+	protecttest.StartDNS(t, SetResolverServers, nil, nil)
+
+	_, err := NewDirectOutbound().DialTCP(context.Background(), Destination{Host: "missing.test", Port: 80})
+	if err == nil {
+		t.Fatal("expected resolve error")
+	}
+}
@@ -1,19 +1,281 @@
 package protocol
 
-import "log"
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"mandala/core/config"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Shadowsocks AEAD 分块最大负载长度 (协议规定高 2 位保留)
+const ssMaxPayload = 0x3FFF
+
+// ssCipherInfo 描述一种 AEAD 加密方式
+type ssCipherInfo struct {
+	keySize  int
+	saltSize int
+	newAEAD  func(key []byte) (cipher.AEAD, error)
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// 支持的 AEAD 加密方式 (与 shadowsocks-libev / shadowsocks-rust 命名一致)
+var ssCiphers = map[string]*ssCipherInfo{
+	"aes-128-gcm":            {keySize: 16, saltSize: 16, newAEAD: newAESGCM},
+	"aes-256-gcm":            {keySize: 32, saltSize: 32, newAEAD: newAESGCM},
+	"chacha20-ietf-poly1305": {keySize: 32, saltSize: 32, newAEAD: chacha20poly1305.New},
+	"chacha20-poly1305":      {keySize: 32, saltSize: 32, newAEAD: chacha20poly1305.New},
+}
 
 // BuildShadowsocksPayload 构造 Shadowsocks 握手包
 // 在 Mandala 架构中，Shadowsocks over TLS/WebSocket 只需要发送标准 SOCKS5 格式的目标地址
 // 格式: [ATYP][ADDR][PORT]
 func BuildShadowsocksPayload(targetHost string, targetPort int) ([]byte, error) {
 	log.Printf("[Shadowsocks] 构造地址 Payload: %s:%d", targetHost, targetPort)
-	
+
 	// 直接复用 utils.go 中的 ToSocksAddr，它生成的正是 SS 需要的格式
 	addr, err := ToSocksAddr(targetHost, targetPort)
 	if err != nil {
 		log.Printf("[Shadowsocks] 地址转换失败: %v", err)
 		return nil, err
 	}
-	
+
 	return addr, nil
 }
+
+// HandshakeShadowsocks 执行 Shadowsocks 客户端握手并返回加密后的连接
+// method 为空 (或 "none"/"plain") 时保持旧行为：仅发送明文地址，依赖外层 TLS/WebSocket 加密
+// method 为 "2022-blake3-*" 时使用 Shadowsocks 2022 协议
+func HandshakeShadowsocks(conn net.Conn, method, password, targetHost string, targetPort int) (net.Conn, error) {
+	method = strings.ToLower(method)
+	if IsShadowsocks2022Method(method) {
+		return HandshakeShadowsocks2022(conn, method, password, targetHost, targetPort)
+	}
+
+	payload, err := BuildShadowsocksPayload(targetHost, targetPort)
+	if err != nil {
+		return nil, err
+	}
+
+	if method == "" || method == "none" || method == "plain" {
+		if _, err := conn.Write(payload); err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	ssConn, err := NewShadowsocksConn(conn, method, password)
+	if err != nil {
+		return nil, err
+	}
+
+	// 目标地址作为第一个加密分块发送
+	if _, err := ssConn.Write(payload); err != nil {
+		return nil, fmt.Errorf("shadowsocks handshake write failed: %v", err)
+	}
+	return ssConn, nil
+}
+
+// ShadowsocksConn 实现 Shadowsocks AEAD 流式加密 (SIP004)
+// 每个方向: [Salt] { [Encrypted Len(2) + Tag] [Encrypted Payload + Tag] }...
+type ShadowsocksConn struct {
+	net.Conn
+	info *ssCipherInfo
+	key  []byte
+
+	writeMu  sync.Mutex
+	enc      cipher.AEAD
+	encNonce []byte
+
+	dec      cipher.AEAD
+	decNonce []byte
+	leftover []byte // 已解密但尚未被读取的数据
+}
+
+// NewShadowsocksConn 根据加密方式与密码包装一个连接
+func NewShadowsocksConn(conn net.Conn, method, password string) (*ShadowsocksConn, error) {
+	info, ok := ssCiphers[strings.ToLower(method)]
+	if !ok {
+		return nil, fmt.Errorf("shadowsocks unsupported method: %s", method)
+	}
+	return &ShadowsocksConn{
+		Conn: conn,
+		info: info,
+		key:  evpBytesToKey(password, info.keySize),
+	}, nil
+}
+
+func (c *ShadowsocksConn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	tagSize := 16
+	chunks := (len(b) + ssMaxPayload - 1) / ssMaxPayload
+	out := make([]byte, 0, c.info.saltSize+len(b)+chunks*(2+2*tagSize))
+
+	// 首次写入时生成 Salt 并派生会话子密钥
+	if c.enc == nil {
+		salt := make([]byte, c.info.saltSize)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return 0, err
+		}
+		aead, err := c.newSubkeyAEAD(salt)
+		if err != nil {
+			return 0, err
+		}
+		c.enc = aead
+		c.encNonce = make([]byte, aead.NonceSize())
+		out = append(out, salt...)
+	}
+
+	lenBuf := make([]byte, 2)
+	for p := b; len(p) > 0; {
+		n := len(p)
+		if n > ssMaxPayload {
+			n = ssMaxPayload
+		}
+		binary.BigEndian.PutUint16(lenBuf, uint16(n))
+		out = c.enc.Seal(out, c.encNonce, lenBuf, nil)
+		incrementNonce(c.encNonce)
+		out = c.enc.Seal(out, c.encNonce, p[:n], nil)
+		incrementNonce(c.encNonce)
+		p = p[n:]
+	}
+
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *ShadowsocksConn) Read(b []byte) (int, error) {
+	if len(c.leftover) > 0 {
+		n := copy(b, c.leftover)
+		c.leftover = c.leftover[n:]
+		return n, nil
+	}
+
+	// 首次读取时获取服务端 Salt
+	if c.dec == nil {
+		salt := make([]byte, c.info.saltSize)
+		if _, err := io.ReadFull(c.Conn, salt); err != nil {
+			return 0, err
+		}
+		aead, err := c.newSubkeyAEAD(salt)
+		if err != nil {
+			return 0, err
+		}
+		c.dec = aead
+		c.decNonce = make([]byte, aead.NonceSize())
+	}
+
+	tagSize := c.dec.Overhead()
+
+	// 1. 读取并解密长度块
+	lenBuf := make([]byte, 2+tagSize)
+	if _, err := io.ReadFull(c.Conn, lenBuf); err != nil {
+		return 0, err
+	}
+	if _, err := c.dec.Open(lenBuf[:0], c.decNonce, lenBuf, nil); err != nil {
+		return 0, errors.New("shadowsocks length chunk authentication failed")
+	}
+	incrementNonce(c.decNonce)
+	size := int(binary.BigEndian.Uint16(lenBuf[:2])) & ssMaxPayload
+
+	// 2. 读取并解密负载块
+	payload := make([]byte, size+tagSize)
+	if _, err := io.ReadFull(c.Conn, payload); err != nil {
+		return 0, err
+	}
+	if _, err := c.dec.Open(payload[:0], c.decNonce, payload, nil); err != nil {
+		return 0, errors.New("shadowsocks payload chunk authentication failed")
+	}
+	incrementNonce(c.decNonce)
+
+	n := copy(b, payload[:size])
+	if n < size {
+		c.leftover = payload[n:size]
+	}
+	return n, nil
+}
+
+// newSubkeyAEAD 使用 HKDF-SHA1 从主密钥与 Salt 派生会话子密钥
+func (c *ShadowsocksConn) newSubkeyAEAD(salt []byte) (cipher.AEAD, error) {
+	return ssSubkeyAEAD(c.info, c.key, salt)
+}
+
+// ssSubkeyAEAD 由主密钥与 Salt 派生子密钥并创建 AEAD，TCP 与 UDP 共用
+func ssSubkeyAEAD(info *ssCipherInfo, key, salt []byte) (cipher.AEAD, error) {
+	subkey, err := ssSubkey(key, salt, info.keySize)
+	if err != nil {
+		return nil, err
+	}
+	return info.newAEAD(subkey)
+}
+
+// ssSubkey 计算 HKDF-SHA1(主密钥, Salt, "ss-subkey")
+func ssSubkey(key, salt []byte, size int) ([]byte, error) {
+	subkey := make([]byte, size)
+	r := hkdf.New(sha1.New, key, salt, []byte("ss-subkey"))
+	if _, err := io.ReadFull(r, subkey); err != nil {
+		return nil, err
+	}
+	return subkey, nil
+}
+
+// evpBytesToKey 等效于 OpenSSL EVP_BytesToKey (MD5, 无 Salt, 1 轮)，用于从密码生成主密钥
+func evpBytesToKey(password string, keyLen int) []byte {
+	var key, prev []byte
+	for len(key) < keyLen {
+		h := md5.New()
+		h.Write(prev)
+		h.Write([]byte(password))
+		prev = h.Sum(nil)
+		key = append(key, prev...)
+	}
+	return key[:keyLen]
+}
+
+// incrementNonce 以小端序对 Nonce 计数器加一
+func incrementNonce(nonce []byte) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
+
+func init() {
+	RegisterOutbound(func(cfg *config.OutboundConfig, dial DialFunc) (Outbound, error) {
+		return &shadowsocksOutbound{
+			streamOutbound: &streamOutbound{
+				dial: dial,
+				handshake: func(conn net.Conn, dest Destination) (net.Conn, error) {
+					return HandshakeShadowsocks(conn, cfg.Method, cfg.Password, dest.Host, dest.Port)
+				},
+			},
+			cfg: cfg,
+		}, nil
+	}, "shadowsocks", "ss")
+}
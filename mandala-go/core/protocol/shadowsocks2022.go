@@ -0,0 +1,315 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Shadowsocks 2022 (SIP022) 常量
+const (
+	ss2022MaxPayload     = 0xFFFF
+	ss2022MaxPadding     = 900
+	ss2022TimeTolerance  = 30 // 秒，时间戳允许的最大偏差
+	ss2022HeaderTypeReq  = 0
+	ss2022HeaderTypeResp = 1
+	ss2022SaltWindow     = 60 * time.Second
+)
+
+// ss2022CipherInfo 描述一种 2022 加密方式
+type ss2022CipherInfo struct {
+	keySize     int
+	newAEAD     func(key []byte) (cipher.AEAD, error)
+	supportsEIH bool // 仅 AES 系列支持多用户身份头 (Extensible Identity Headers)
+}
+
+var ss2022Ciphers = map[string]*ss2022CipherInfo{
+	"2022-blake3-aes-128-gcm":       {keySize: 16, newAEAD: newAESGCM, supportsEIH: true},
+	"2022-blake3-aes-256-gcm":       {keySize: 32, newAEAD: newAESGCM, supportsEIH: true},
+	"2022-blake3-chacha20-poly1305": {keySize: 32, newAEAD: chacha20poly1305.New},
+}
+
+// IsShadowsocks2022Method 判断加密方式是否属于 2022 系列
+func IsShadowsocks2022Method(method string) bool {
+	_, ok := ss2022Ciphers[strings.ToLower(method)]
+	return ok
+}
+
+// ss2022SaltPool 记录近期见过的服务端 Salt，用于防重放
+var ss2022SaltPool = struct {
+	sync.Mutex
+	seen map[string]time.Time
+}{seen: make(map[string]time.Time)}
+
+func ss2022CheckSalt(salt []byte) bool {
+	ss2022SaltPool.Lock()
+	defer ss2022SaltPool.Unlock()
+
+	now := time.Now()
+	for k, t := range ss2022SaltPool.seen {
+		if now.Sub(t) > ss2022SaltWindow {
+			delete(ss2022SaltPool.seen, k)
+		}
+	}
+	if _, ok := ss2022SaltPool.seen[string(salt)]; ok {
+		return false
+	}
+	ss2022SaltPool.seen[string(salt)] = now
+	return true
+}
+
+// parseSS2022Keys 解析 Base64 PSK 列表
+// 多用户格式: "iPSK1:iPSK2:...:uPSK"，最后一个为用户 PSK，其余为身份 PSK
+func parseSS2022Keys(password string, keySize int) ([][]byte, error) {
+	parts := strings.Split(password, ":")
+	keys := make([][]byte, 0, len(parts))
+	for _, p := range parts {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(p))
+		if err != nil {
+			return nil, fmt.Errorf("shadowsocks 2022 invalid base64 key: %v", err)
+		}
+		if len(key) != keySize {
+			return nil, fmt.Errorf("shadowsocks 2022 key length %d, expected %d", len(key), keySize)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Shadowsocks2022Conn 实现 2022-blake3-* 系列的 TCP 流加密
+type Shadowsocks2022Conn struct {
+	net.Conn
+	info        *ss2022CipherInfo
+	psk         []byte // 用户 PSK，用于派生会话子密钥
+	requestSalt []byte
+
+	writeMu  sync.Mutex
+	enc      cipher.AEAD
+	encNonce []byte
+
+	dec      cipher.AEAD
+	decNonce []byte
+	leftover []byte
+}
+
+// HandshakeShadowsocks2022 发送 2022 请求头并返回加密连接
+// 请求结构: [Salt] [EIH...] [Seal(FixedHeader)] [Seal(VariableHeader)]
+func HandshakeShadowsocks2022(conn net.Conn, method, password, targetHost string, targetPort int) (net.Conn, error) {
+	method = strings.ToLower(method)
+	info, ok := ss2022Ciphers[method]
+	if !ok {
+		return nil, fmt.Errorf("shadowsocks unsupported method: %s", method)
+	}
+
+	keys, err := parseSS2022Keys(password, info.keySize)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) > 1 && !info.supportsEIH {
+		return nil, fmt.Errorf("shadowsocks 2022 method %s does not support identity headers", method)
+	}
+	log.Printf("[Shadowsocks2022] 开始握手 -> %s:%d (Method: %s, 身份头: %d)", targetHost, targetPort, method, len(keys)-1)
+
+	c := &Shadowsocks2022Conn{
+		Conn: conn,
+		info: info,
+		psk:  keys[len(keys)-1],
+	}
+
+	salt := make([]byte, info.keySize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	c.requestSalt = salt
+
+	c.enc, err = c.sessionAEAD(salt)
+	if err != nil {
+		return nil, err
+	}
+	c.encNonce = make([]byte, c.enc.NonceSize())
+
+	var out bytes.Buffer
+	out.Write(salt)
+
+	// 1. 多用户身份头: AES-ECB(IdentitySubkey(iPSK_i, Salt), BLAKE3(PSK_{i+1})[:16])
+	for i := 0; i < len(keys)-1; i++ {
+		identitySubkey := Blake3DeriveKey("shadowsocks 2022 identity subkey", append(append([]byte{}, keys[i]...), salt...), info.keySize)
+		block, err := aes.NewCipher(identitySubkey)
+		if err != nil {
+			return nil, err
+		}
+		eih := make([]byte, aes.BlockSize)
+		block.Encrypt(eih, Blake3Sum256(keys[i+1])[:aes.BlockSize])
+		out.Write(eih)
+	}
+
+	// 2. 可变长度头: [ATYP][ADDR][PORT] [PaddingLen(2)] [Padding]
+	// 没有初始负载时必须携带 1~900 字节随机填充
+	addr, err := ToSocksAddr(targetHost, targetPort)
+	if err != nil {
+		return nil, err
+	}
+	padN, err := rand.Int(rand.Reader, big.NewInt(ss2022MaxPadding))
+	if err != nil {
+		return nil, err
+	}
+	padLen := int(padN.Int64()) + 1
+
+	varHeader := make([]byte, len(addr)+2+padLen)
+	copy(varHeader, addr)
+	binary.BigEndian.PutUint16(varHeader[len(addr):], uint16(padLen))
+	if _, err := io.ReadFull(rand.Reader, varHeader[len(addr)+2:]); err != nil {
+		return nil, err
+	}
+
+	// 3. 固定长度头: [Type(1)] [Timestamp(8)] [VarHeaderLen(2)]
+	fixedHeader := make([]byte, 1+8+2)
+	fixedHeader[0] = ss2022HeaderTypeReq
+	binary.BigEndian.PutUint64(fixedHeader[1:], uint64(time.Now().Unix()))
+	binary.BigEndian.PutUint16(fixedHeader[9:], uint16(len(varHeader)))
+
+	sealed := c.enc.Seal(nil, c.encNonce, fixedHeader, nil)
+	incrementNonce(c.encNonce)
+	out.Write(sealed)
+	sealed = c.enc.Seal(nil, c.encNonce, varHeader, nil)
+	incrementNonce(c.encNonce)
+	out.Write(sealed)
+
+	if _, err := conn.Write(out.Bytes()); err != nil {
+		return nil, fmt.Errorf("shadowsocks 2022 handshake write failed: %v", err)
+	}
+	return c, nil
+}
+
+// sessionAEAD 使用 BLAKE3 derive_key 从 PSK 与 Salt 派生会话子密钥
+func (c *Shadowsocks2022Conn) sessionAEAD(salt []byte) (cipher.AEAD, error) {
+	material := make([]byte, 0, len(c.psk)+len(salt))
+	material = append(material, c.psk...)
+	material = append(material, salt...)
+	return c.info.newAEAD(Blake3DeriveKey("shadowsocks 2022 session subkey", material, c.info.keySize))
+}
+
+func (c *Shadowsocks2022Conn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	tagSize := c.enc.Overhead()
+	chunks := (len(b) + ss2022MaxPayload - 1) / ss2022MaxPayload
+	out := make([]byte, 0, len(b)+chunks*(2+2*tagSize))
+
+	lenBuf := make([]byte, 2)
+	for p := b; len(p) > 0; {
+		n := len(p)
+		if n > ss2022MaxPayload {
+			n = ss2022MaxPayload
+		}
+		binary.BigEndian.PutUint16(lenBuf, uint16(n))
+		out = c.enc.Seal(out, c.encNonce, lenBuf, nil)
+		incrementNonce(c.encNonce)
+		out = c.enc.Seal(out, c.encNonce, p[:n], nil)
+		incrementNonce(c.encNonce)
+		p = p[n:]
+	}
+
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *Shadowsocks2022Conn) Read(b []byte) (int, error) {
+	if len(c.leftover) > 0 {
+		n := copy(b, c.leftover)
+		c.leftover = c.leftover[n:]
+		return n, nil
+	}
+
+	var size int
+	if c.dec == nil {
+		var err error
+		if size, err = c.readResponseHeader(); err != nil {
+			return 0, err
+		}
+	} else {
+		// 读取并解密长度块
+		lenBuf := make([]byte, 2+c.dec.Overhead())
+		if _, err := io.ReadFull(c.Conn, lenBuf); err != nil {
+			return 0, err
+		}
+		if _, err := c.dec.Open(lenBuf[:0], c.decNonce, lenBuf, nil); err != nil {
+			return 0, errors.New("shadowsocks 2022 length chunk authentication failed")
+		}
+		incrementNonce(c.decNonce)
+		size = int(binary.BigEndian.Uint16(lenBuf[:2]))
+	}
+
+	payload := make([]byte, size+c.dec.Overhead())
+	if _, err := io.ReadFull(c.Conn, payload); err != nil {
+		return 0, err
+	}
+	if _, err := c.dec.Open(payload[:0], c.decNonce, payload, nil); err != nil {
+		return 0, errors.New("shadowsocks 2022 payload chunk authentication failed")
+	}
+	incrementNonce(c.decNonce)
+
+	n := copy(b, payload[:size])
+	if n < size {
+		c.leftover = payload[n:size]
+	}
+	return n, nil
+}
+
+// readResponseHeader 读取服务端响应头并校验时间戳、请求 Salt 与重放
+// 响应结构: [Salt] [Seal(Type(1) Timestamp(8) RequestSalt LenOfFirstChunk(2))]
+// 返回第一个负载块的长度
+func (c *Shadowsocks2022Conn) readResponseHeader() (int, error) {
+	salt := make([]byte, c.info.keySize)
+	if _, err := io.ReadFull(c.Conn, salt); err != nil {
+		return 0, err
+	}
+	if !ss2022CheckSalt(salt) {
+		return 0, errors.New("shadowsocks 2022 replayed server salt")
+	}
+
+	aead, err := c.sessionAEAD(salt)
+	if err != nil {
+		return 0, err
+	}
+	c.dec = aead
+	c.decNonce = make([]byte, aead.NonceSize())
+
+	header := make([]byte, 1+8+len(c.requestSalt)+2+aead.Overhead())
+	if _, err := io.ReadFull(c.Conn, header); err != nil {
+		return 0, err
+	}
+	if _, err := aead.Open(header[:0], c.decNonce, header, nil); err != nil {
+		return 0, errors.New("shadowsocks 2022 response header authentication failed")
+	}
+	incrementNonce(c.decNonce)
+
+	if header[0] != ss2022HeaderTypeResp {
+		return 0, fmt.Errorf("shadowsocks 2022 unexpected header type: %d", header[0])
+	}
+	ts := int64(binary.BigEndian.Uint64(header[1:9]))
+	if diff := time.Now().Unix() - ts; diff > ss2022TimeTolerance || diff < -ss2022TimeTolerance {
+		return 0, fmt.Errorf("shadowsocks 2022 response timestamp out of range (%ds)", diff)
+	}
+	if !bytes.Equal(header[9:9+len(c.requestSalt)], c.requestSalt) {
+		return 0, errors.New("shadowsocks 2022 request salt mismatch")
+	}
+	return int(binary.BigEndian.Uint16(header[9+len(c.requestSalt):])), nil
+}
@@ -0,0 +1,224 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ss2022TestServer 是按 SIP022 编写的最小服务端: 校验请求头后将第一个数据块原样返回
+type ss2022TestServer struct {
+	info *ss2022CipherInfo
+	keys [][]byte // 身份 PSK... 用户 PSK
+	salt []byte   // 响应 Salt，为 nil 时随机生成
+}
+
+func (s *ss2022TestServer) session(salt []byte) (aeadSealer, error) {
+	material := append(append([]byte{}, s.keys[len(s.keys)-1]...), salt...)
+	aead, err := s.info.newAEAD(Blake3DeriveKey("shadowsocks 2022 session subkey", material, s.info.keySize))
+	if err != nil {
+		return aeadSealer{}, err
+	}
+	return aeadSealer{aead: aead, nonce: make([]byte, aead.NonceSize())}, nil
+}
+
+func (s *ss2022TestServer) serve(conn net.Conn) (string, error) {
+	defer conn.Close()
+
+	reqSalt := make([]byte, s.info.keySize)
+	if _, err := io.ReadFull(conn, reqSalt); err != nil {
+		return "", err
+	}
+	for i := 0; i < len(s.keys)-1; i++ {
+		eih := make([]byte, aes.BlockSize)
+		if _, err := io.ReadFull(conn, eih); err != nil {
+			return "", err
+		}
+		block, _ := aes.NewCipher(Blake3DeriveKey("shadowsocks 2022 identity subkey", append(append([]byte{}, s.keys[i]...), reqSalt...), s.info.keySize))
+		block.Decrypt(eih, eih)
+		if !bytes.Equal(eih, Blake3Sum256(s.keys[i+1])[:aes.BlockSize]) {
+			return "", errors.New("identity header mismatch")
+		}
+	}
+
+	dec, err := s.session(reqSalt)
+	if err != nil {
+		return "", err
+	}
+	fixed, err := dec.open(conn, 1+8+2)
+	if err != nil {
+		return "", err
+	}
+	if fixed[0] != 0 {
+		return "", errors.New("unexpected request type")
+	}
+	if d := time.Now().Unix() - int64(binary.BigEndian.Uint64(fixed[1:9])); d > 30 || d < -30 {
+		return "", errors.New("request timestamp out of range")
+	}
+	varHeader, err := dec.open(conn, int(binary.BigEndian.Uint16(fixed[9:])))
+	if err != nil {
+		return "", err
+	}
+	r := bytes.NewReader(varHeader)
+	host, port, err := ReadSocksAddr(r)
+	if err != nil {
+		return "", err
+	}
+	var padLen uint16
+	if binary.Read(r, binary.BigEndian, &padLen) != nil || padLen == 0 || int(padLen) != r.Len() {
+		return "", errors.New("invalid padding")
+	}
+
+	// 第一个数据块
+	size, err := dec.open(conn, 2)
+	if err != nil {
+		return "", err
+	}
+	payload, err := dec.open(conn, int(binary.BigEndian.Uint16(size)))
+	if err != nil {
+		return "", err
+	}
+
+	respSalt := s.salt
+	if respSalt == nil {
+		respSalt = make([]byte, s.info.keySize)
+		rand.Read(respSalt)
+	}
+	enc, err := s.session(respSalt)
+	if err != nil {
+		return "", err
+	}
+	header := []byte{1}
+	header = binary.BigEndian.AppendUint64(header, uint64(time.Now().Unix()))
+	header = append(header, reqSalt...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	out := append([]byte{}, respSalt...)
+	out = enc.seal(out, header)
+	out = enc.seal(out, payload)
+	if _, err := conn.Write(out); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// aeadSealer 按 SIP022 的递增 nonce 加解密
+type aeadSealer struct {
+	aead  cipher.AEAD
+	nonce []byte
+}
+
+func (a aeadSealer) seal(out, plain []byte) []byte {
+	out = a.aead.Seal(out, a.nonce, plain, nil)
+	incrementNonce(a.nonce)
+	return out
+}
+
+func (a aeadSealer) open(r io.Reader, size int) ([]byte, error) {
+	buf := make([]byte, size+a.aead.Overhead())
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	plain, err := a.aead.Open(buf[:0], a.nonce, buf, nil)
+	incrementNonce(a.nonce)
+	return plain, err
+}
+
+func ss2022TestKeys(size, n int) ([][]byte, string) {
+	keys := make([][]byte, n)
+	encoded := make([]string, n)
+	for i := range keys {
+		keys[i] = make([]byte, size)
+		rand.Read(keys[i])
+		encoded[i] = base64.StdEncoding.EncodeToString(keys[i])
+	}
+	return keys, strings.Join(encoded, ":")
+}
+
+// ss2022Exchange 完成一次握手并发送 data，返回服务端看到的目标与客户端读到的回显，客户端读取失败时返回该错误
+func ss2022Exchange(t *testing.T, method string, srv *ss2022TestServer, password string, data []byte) (string, []byte, error) {
+	t.Helper()
+	client, server := net.Pipe()
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	type result struct {
+		target string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		target, err := srv.serve(server)
+		done <- result{target, err}
+	}()
+
+	conn, err := HandshakeShadowsocks2022(client, method, password, "example.com", 443)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(data); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	echo := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, echo); err != nil {
+		client.Close() // 让仍在写响应的服务端退出
+		<-done
+		return "", nil, err
+	}
+	res := <-done
+	if res.err != nil {
+		t.Fatalf("server: %v", res.err)
+	}
+	return res.target, echo, nil
+}
+
+func TestShadowsocks2022RoundTrip(t *testing.T) {
+	for _, c := range []struct {
+		method string
+		users  int // 密钥个数，大于 1 时携带身份头
+	}{
+		{"2022-blake3-aes-128-gcm", 1},
+		{"2022-blake3-aes-256-gcm", 1},
+		{"2022-blake3-chacha20-poly1305", 1},
+		{"2022-blake3-aes-128-gcm", 3},
+	} {
+		info := ss2022Ciphers[c.method]
+		keys, password := ss2022TestKeys(info.keySize, c.users)
+		data := []byte("GET / HTTP/1.1\r\n\r\n")
+
+		target, echo, err := ss2022Exchange(t, c.method, &ss2022TestServer{info: info, keys: keys}, password, data)
+		if err != nil || !bytes.Equal(echo, data) {
+			t.Fatalf("%s (%d keys): echo %q, %v", c.method, c.users, echo, err)
+		}
+		if target != "example.com:443" {
+			t.Errorf("%s: target = %s", c.method, target)
+		}
+	}
+}
+
+func TestShadowsocks2022RejectsReplayedSalt(t *testing.T) {
+	method := "2022-blake3-aes-256-gcm"
+	info := ss2022Ciphers[method]
+	keys, password := ss2022TestKeys(info.keySize, 1)
+	salt := make([]byte, info.keySize)
+	rand.Read(salt)
+	srv := &ss2022TestServer{info: info, keys: keys, salt: salt}
+
+	if _, _, err := ss2022Exchange(t, method, srv, password, []byte("first")); err != nil {
+		t.Fatalf("first response: %v", err)
+	}
+	// 重放同一个服务端 Salt 的响应必须被拒绝
+	_, _, err := ss2022Exchange(t, method, srv, password, []byte("again"))
+	if err == nil || !strings.Contains(err.Error(), "replayed server salt") {
+		t.Fatalf("replayed salt: err = %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package protocol
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestEVPBytesToKey(t *testing.T) {
+	// 期望值来自 openssl enc -aes-{128,256}-cbc -k 'barfoo!' -nosalt -md md5 -P
+	for _, c := range []struct {
+		size int
+		want string
+	}{
+		{16, "b3adc47839e047eb228870526dc8fc30"},
+		{32, "b3adc47839e047eb228870526dc8fc30b347287ffca3045dcea06b3fdf090acb"},
+	} {
+		if got := hex.EncodeToString(evpBytesToKey("barfoo!", c.size)); got != c.want {
+			t.Errorf("evpBytesToKey(%d) = %s, want %s", c.size, got, c.want)
+		}
+	}
+}
+
+func TestShadowsocksSubkey(t *testing.T) {
+	// 期望值为按 RFC 5869 以 HMAC-SHA1 独立计算的 HKDF(主密钥, Salt = 0x00..0x{size-1}, "ss-subkey")
+	for _, c := range []struct {
+		size int
+		want string
+	}{
+		{16, "9cd21fb890a57fbe98653cbadd4d047c"},
+		{32, "6e62f41174d7879ffea269ebf7805b730f62002e2b461f4dcb2a21dfb6f6423e"},
+	} {
+		salt := make([]byte, c.size)
+		for i := range salt {
+			salt[i] = byte(i)
+		}
+		subkey, err := ssSubkey(evpBytesToKey("barfoo!", c.size), salt, c.size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(subkey); got != c.want {
+			t.Errorf("subkey(%d) = %s, want %s", c.size, got, c.want)
+		}
+	}
+}
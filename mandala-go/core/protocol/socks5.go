@@ -4,6 +4,16 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
+
+	"mandala/core/config"
+)
+
+// SOCKS5 客户端指令
+const (
+	socks5CmdConnect      = 0x01
+	socks5CmdBind         = 0x02
+	socks5CmdUDPAssociate = 0x03
 )
 
 // HandshakeSocks5 执行 SOCKS5 客户端握手
@@ -11,6 +21,43 @@ import (
 // [新增] 详细的流程日志记录
 func HandshakeSocks5(conn io.ReadWriter, username, password, targetHost string, targetPort int) error {
 	log.Printf("[Socks5] 开始握手: 目标=%s:%d, 用户名=%s", targetHost, targetPort, username)
+	if err := socks5Authenticate(conn, username, password); err != nil {
+		return err
+	}
+	if _, err := socks5Request(conn, socks5CmdConnect, targetHost, targetPort); err != nil {
+		return err
+	}
+	log.Printf("[Socks5] 连接建立完成")
+	return nil
+}
+
+// Socks5Bind 发送 BIND 请求，返回服务端为入站连接开放的监听地址
+// 随后调用 Socks5AcceptBind 等待第二个应答 (对端连入)，之后 conn 即为与对端的数据通道
+func Socks5Bind(conn io.ReadWriter, username, password, targetHost string, targetPort int) (Destination, error) {
+	log.Printf("[Socks5] 开始 BIND 握手: 预期对端=%s:%d", targetHost, targetPort)
+	if err := socks5Authenticate(conn, username, password); err != nil {
+		return Destination{}, err
+	}
+	bound, err := socks5Request(conn, socks5CmdBind, targetHost, targetPort)
+	if err != nil {
+		return Destination{}, err
+	}
+	log.Printf("[Socks5] BIND 监听地址: %s", bound)
+	return bound, nil
+}
+
+// Socks5AcceptBind 阻塞读取 BIND 的第二个应答，返回连入的对端地址
+func Socks5AcceptBind(conn io.Reader) (Destination, error) {
+	peer, err := socks5ReadReply(conn)
+	if err != nil {
+		return Destination{}, err
+	}
+	log.Printf("[Socks5] BIND 对端已连入: %s", peer)
+	return peer, nil
+}
+
+// socks5Authenticate 完成方法协商与 (可选的) 用户名密码认证
+func socks5Authenticate(conn io.ReadWriter, username, password string) error {
 
 	// 1. 发送版本和支持的认证方法
 	var methods []byte
@@ -86,50 +133,61 @@ func HandshakeSocks5(conn io.ReadWriter, username, password, targetHost string,
 		return fmt.Errorf("socks5 unsupported auth method selected: 0x%02x", authMethod)
 	}
 
-	// 4. 发送连接请求 (CONNECT CMD=0x01)
-	log.Printf("[Socks5] 发送连接请求 (CMD=0x01) 到目标地址")
-	head := []byte{0x05, 0x01, 0x00}
-	addr, err := ToSocksAddr(targetHost, targetPort) 
+	return nil
+}
+
+// socks5Request 发送请求 [VER][CMD][RSV][DST.ADDR][DST.PORT] 并读取应答，返回 BND 地址
+func socks5Request(conn io.ReadWriter, cmd byte, targetHost string, targetPort int) (Destination, error) {
+	log.Printf("[Socks5] 发送请求 (CMD=0x%02x) 到目标地址", cmd)
+	head := []byte{0x05, cmd, 0x00}
+	addr, err := ToSocksAddr(targetHost, targetPort)
 	if err != nil {
-		return err
+		return Destination{}, err
 	}
-	
+
 	if _, err := conn.Write(append(head, addr...)); err != nil {
-		return fmt.Errorf("socks5 connect write failed: %v", err)
+		return Destination{}, fmt.Errorf("socks5 request write failed: %v", err)
 	}
+	return socks5ReadReply(conn)
+}
 
-	// 5. 读取连接响应
-	connRespHead := make([]byte, 4)
-	if _, err := io.ReadFull(conn, connRespHead); err != nil {
-		return fmt.Errorf("socks5 connect resp header read failed: %v", err)
+// socks5ReadReply 读取应答 [VER][REP][RSV][BND.ADDR][BND.PORT]
+func socks5ReadReply(conn io.Reader) (Destination, error) {
+	respHead := make([]byte, 3)
+	if _, err := io.ReadFull(conn, respHead); err != nil {
+		return Destination{}, fmt.Errorf("socks5 resp header read failed: %v", err)
 	}
 
 	// REP 字段: 0x00 表示成功
-	if connRespHead[1] != 0x00 {
-		log.Printf("[Socks5] 连接目标失败，错误码: 0x%02x", connRespHead[1])
-		return fmt.Errorf("socks5 connect failed with error: 0x%02x", connRespHead[1])
-	}
-
-	// 读取剩余的 BND.ADDR 和 BND.PORT
-	var left int
-	switch connRespHead[3] {
-	case 0x01: left = 4 + 2 // IPv4
-	case 0x04: left = 16 + 2 // IPv6
-	case 0x03: // Domain
-		lenByte := make([]byte, 1)
-		if _, err := io.ReadFull(conn, lenByte); err != nil {
-			return fmt.Errorf("socks5 read domain len failed: %v", err)
-		}
-		left = int(lenByte[0]) + 2
-	default:
-		return fmt.Errorf("socks5 invalid address type in response: 0x%02x", connRespHead[3])
+	if respHead[1] != 0x00 {
+		log.Printf("[Socks5] 请求失败，错误码: 0x%02x", respHead[1])
+		return Destination{}, fmt.Errorf("socks5 request failed with error: 0x%02x", respHead[1])
 	}
 
-	discard := make([]byte, left)
-	if _, err := io.ReadFull(conn, discard); err != nil {
-		return fmt.Errorf("socks5 connect resp body read failed: %v", err)
+	host, port, err := ReadSocksAddr(conn)
+	if err != nil {
+		return Destination{}, fmt.Errorf("socks5 resp body read failed: %v", err)
 	}
+	return Destination{Host: host, Port: port}, nil
+}
 
-	log.Printf("[Socks5] 连接建立完成")
-	return nil
+func init() {
+	RegisterOutbound(func(cfg *config.OutboundConfig, dial DialFunc) (Outbound, error) {
+		return &streamOutbound{
+			dial: dial,
+			handshake: func(conn net.Conn, dest Destination) (net.Conn, error) {
+				if err := HandshakeSocks5(conn, cfg.Username, cfg.Password, dest.Host, dest.Port); err != nil {
+					return nil, err
+				}
+				return conn, nil
+			},
+			udpHandshake: func(conn net.Conn, dest Destination) (net.Conn, error) {
+				pc, err := HandshakeSocks5UDP(conn, cfg.Username, cfg.Password, cfg.Server)
+				if err != nil {
+					return nil, err
+				}
+				return pc.Session(dest), nil
+			},
+		}, nil
+	}, "socks", "socks5")
 }
@@ -0,0 +1,140 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+// socksUDPAddr 表示 SOCKS5 UDP 头中的地址 (可能是域名，因此不能用 net.UDPAddr 表示)
+type socksUDPAddr struct {
+	Destination
+}
+
+func (a socksUDPAddr) Network() string { return "udp" }
+
+// Socks5PacketConn 是通过上游 SOCKS5 UDP ASSOCIATE 建立的数据报连接
+// 每个数据报以 RFC 1928 头 [RSV(2)][FRAG(1)][ATYP][ADDR][PORT] 封装后发往中继地址
+// 控制用的 TCP 连接必须保持打开，关闭它即结束关联
+type Socks5PacketConn struct {
+	udp     *net.UDPConn
+	control net.Conn
+	relay   *net.UDPAddr
+}
+
+// HandshakeSocks5UDP 在 control 连接上完成认证并发送 UDP ASSOCIATE，返回到中继的数据报连接
+// serverHost 用于中继地址为 0.0.0.0 / :: 时的回退 (常见于 NAT 后的服务端)
+func HandshakeSocks5UDP(control net.Conn, username, password, serverHost string) (*Socks5PacketConn, error) {
+	log.Printf("[Socks5] 开始 UDP ASSOCIATE 握手, 用户名=%s", username)
+	if err := socks5Authenticate(control, username, password); err != nil {
+		return nil, err
+	}
+	bound, err := socks5Request(control, socks5CmdUDPAssociate, "0.0.0.0", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	relayHost := bound.Host
+	if ip := net.ParseIP(relayHost); ip == nil || ip.IsUnspecified() {
+		relayHost = serverHost
+	}
+	// 中继地址可能为域名 (serverHost)，由受保护的解析器解析
+	conn, err := NewProtectedDialer(5*time.Second).Dial("udp", net.JoinHostPort(relayHost, strconv.Itoa(bound.Port)))
+	if err != nil {
+		return nil, fmt.Errorf("socks5 dial udp relay failed: %v", err)
+	}
+	udpConn := conn.(*net.UDPConn)
+	relay := udpConn.RemoteAddr().(*net.UDPAddr)
+	control.SetDeadline(time.Time{})
+
+	// 服务端关闭控制连接即表示关联结束
+	go func() {
+		io.Copy(io.Discard, control)
+		udpConn.Close()
+	}()
+
+	log.Printf("[Socks5] UDP 中继地址: %s", relay)
+	return &Socks5PacketConn{udp: udpConn, control: control, relay: relay}, nil
+}
+
+// WriteTo 发送一个数据报到 addr (addr 可以是 *net.UDPAddr 或任意 host:port 形式的地址)
+func (c *Socks5PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	host, port, err := SplitHostPort(addr.String())
+	if err != nil {
+		return 0, err
+	}
+	header, err := ToSocksAddr(host, port)
+	if err != nil {
+		return 0, err
+	}
+
+	packet := make([]byte, 0, 3+len(header)+len(b))
+	packet = append(packet, 0x00, 0x00, 0x00) // RSV + FRAG(0)
+	packet = append(packet, header...)
+	packet = append(packet, b...)
+	if _, err := c.udp.Write(packet); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// ReadFrom 读取一个数据报并解析来源地址，分片数据报被丢弃
+func (c *Socks5PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	buf := make([]byte, 65535)
+	for {
+		n, err := c.udp.Read(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		if n < 4 || buf[2] != 0x00 {
+			continue
+		}
+		reader := bytes.NewReader(buf[3:n])
+		host, port, err := ReadSocksAddr(reader)
+		if err != nil {
+			continue
+		}
+		payload := buf[n-reader.Len() : n]
+		return copy(b, payload), socksUDPAddr{Destination{Host: host, Port: port}}, nil
+	}
+}
+
+func (c *Socks5PacketConn) Close() error {
+	c.control.Close()
+	return c.udp.Close()
+}
+
+func (c *Socks5PacketConn) LocalAddr() net.Addr                { return c.udp.LocalAddr() }
+func (c *Socks5PacketConn) SetDeadline(t time.Time) error      { return c.udp.SetDeadline(t) }
+func (c *Socks5PacketConn) SetReadDeadline(t time.Time) error  { return c.udp.SetReadDeadline(t) }
+func (c *Socks5PacketConn) SetWriteDeadline(t time.Time) error { return c.udp.SetWriteDeadline(t) }
+
+// Session 返回一个绑定到 dest 的 net.Conn，供 TUN NAT 等按目标建会话的场景使用
+func (c *Socks5PacketConn) Session(dest Destination) net.Conn {
+	return &socks5UDPSession{Socks5PacketConn: c, dest: socksUDPAddr{dest}}
+}
+
+type socks5UDPSession struct {
+	*Socks5PacketConn
+	dest socksUDPAddr
+}
+
+func (s *socks5UDPSession) Write(b []byte) (int, error) {
+	return s.WriteTo(b, s.dest)
+}
+
+func (s *socks5UDPSession) Read(b []byte) (int, error) {
+	n, _, err := s.ReadFrom(b)
+	return n, err
+}
+
+func (s *socks5UDPSession) RemoteAddr() net.Addr {
+	return s.dest
+}
+
+var _ net.PacketConn = (*Socks5PacketConn)(nil)
+var _ net.Conn = (*socks5UDPSession)(nil)
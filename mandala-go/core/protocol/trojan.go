@@ -3,12 +3,31 @@ package protocol
 import (
 	"bytes"
 	"log"
+	"net"
+
+	"mandala/core/config"
+)
+
+// Trojan 指令
+const (
+	trojanCmdConnect      = 0x01
+	trojanCmdUDPAssociate = 0x03
 )
 
 // BuildTrojanPayload 构造标准 Trojan 握手包
 // 结构: Hash(pass) + CRLF + CMD(1) + SOCKS5_ADDR + CRLF
 func BuildTrojanPayload(password, targetHost string, targetPort int) ([]byte, error) {
-	log.Printf("[Trojan] 正在构造握手包 -> %s:%d", targetHost, targetPort)
+	return buildTrojanRequest(password, trojanCmdConnect, targetHost, targetPort)
+}
+
+// BuildTrojanUDPPayload 构造 Trojan UDP ASSOCIATE 握手包
+// 之后的每个数据报使用 [SOCKS5_ADDR][Length(2)][CRLF][Payload] 封装
+func BuildTrojanUDPPayload(password, targetHost string, targetPort int) ([]byte, error) {
+	return buildTrojanRequest(password, trojanCmdUDPAssociate, targetHost, targetPort)
+}
+
+func buildTrojanRequest(password string, cmd byte, targetHost string, targetPort int) ([]byte, error) {
+	log.Printf("[Trojan] 正在构造握手包 -> %s:%d (CMD: 0x%02x)", targetHost, targetPort, cmd)
 	var buf bytes.Buffer
 
 	// 1. 密码哈希
@@ -17,8 +36,8 @@ func BuildTrojanPayload(password, targetHost string, targetPort int) ([]byte, er
 	buf.Write([]byte{0x0D, 0x0A}) 
 	log.Printf("[Trojan] 密码哈希已写入")
 
-	// 2. 指令 (0x01 Connect)
-	buf.WriteByte(0x01)
+	// 2. 指令 (0x01 Connect / 0x03 UDP Associate)
+	buf.WriteByte(cmd)
 
 	// 3. 目标地址
 	addr, err := ToSocksAddr(targetHost, targetPort)
@@ -34,3 +53,28 @@ func BuildTrojanPayload(password, targetHost string, targetPort int) ([]byte, er
 	log.Printf("[Trojan] 握手包构造成功")
 	return buf.Bytes(), nil
 }
+
+func init() {
+	RegisterOutbound(func(cfg *config.OutboundConfig, dial DialFunc) (Outbound, error) {
+		return &streamOutbound{
+			dial: dial,
+			handshake: func(conn net.Conn, dest Destination) (net.Conn, error) {
+				payload, err := BuildTrojanPayload(cfg.Password, dest.Host, dest.Port)
+				if err != nil {
+					return nil, err
+				}
+				return writePayload(conn, payload)
+			},
+			udpHandshake: func(conn net.Conn, dest Destination) (net.Conn, error) {
+				payload, err := BuildTrojanUDPPayload(cfg.Password, dest.Host, dest.Port)
+				if err != nil {
+					return nil, err
+				}
+				if _, err := writePayload(conn, payload); err != nil {
+					return nil, err
+				}
+				return NewAddrPacketConn(conn, dest), nil
+			},
+		}, nil
+	}, "trojan")
+}
@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// UDP over TCP 单个数据报的最大长度
+const maxUDPPayload = 65535
+
+// AddrPacketConn 实现 Trojan / Mandala 的 UDP 数据报封装
+// 每个数据报: [SOCKS5_ADDR][Length(2)][CRLF][Payload]
+// 每次 Write 发送一个数据报到 dest，每次 Read 返回一个完整数据报
+type AddrPacketConn struct {
+	net.Conn
+	dest    Destination
+	writeMu sync.Mutex
+}
+
+func NewAddrPacketConn(conn net.Conn, dest Destination) *AddrPacketConn {
+	return &AddrPacketConn{Conn: conn, dest: dest}
+}
+
+func (c *AddrPacketConn) Write(b []byte) (int, error) {
+	if len(b) > maxUDPPayload {
+		return 0, errors.New("udp payload too large")
+	}
+	addr, err := ToSocksAddr(c.dest.Host, c.dest.Port)
+	if err != nil {
+		return 0, err
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(addr) + 4 + len(b))
+	buf.Write(addr)
+	binary.Write(&buf, binary.BigEndian, uint16(len(b)))
+	buf.Write([]byte{0x0D, 0x0A})
+	buf.Write(b)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.Conn.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *AddrPacketConn) Read(b []byte) (int, error) {
+	// 源地址对 TUN NAT 会话无意义 (会话已绑定目标)，读取后丢弃
+	if _, _, err := ReadSocksAddr(c.Conn); err != nil {
+		return 0, err
+	}
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(c.Conn, head); err != nil {
+		return 0, err
+	}
+	if head[2] != 0x0D || head[3] != 0x0A {
+		return 0, errors.New("udp frame missing CRLF")
+	}
+	return readDatagram(c.Conn, b, int(binary.BigEndian.Uint16(head)))
+}
+
+// LengthPacketConn 实现 VLESS 的 UDP 数据报封装
+// 每个数据报: [Length(2)][Payload]
+type LengthPacketConn struct {
+	net.Conn
+	writeMu sync.Mutex
+}
+
+func NewLengthPacketConn(conn net.Conn) *LengthPacketConn {
+	return &LengthPacketConn{Conn: conn}
+}
+
+func (c *LengthPacketConn) Write(b []byte) (int, error) {
+	if len(b) > maxUDPPayload {
+		return 0, errors.New("udp payload too large")
+	}
+	buf := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(buf, uint16(len(b)))
+	copy(buf[2:], b)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.Conn.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *LengthPacketConn) Read(b []byte) (int, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(c.Conn, head); err != nil {
+		return 0, err
+	}
+	return readDatagram(c.Conn, b, int(binary.BigEndian.Uint16(head)))
+}
+
+// readDatagram 读取长度为 size 的数据报，超出 b 容量的部分被丢弃 (与 UDP 截断语义一致)
+func readDatagram(r io.Reader, b []byte, size int) (int, error) {
+	if size <= len(b) {
+		return io.ReadFull(r, b[:size])
+	}
+	n, err := io.ReadFull(r, b)
+	if err != nil {
+		return n, err
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(size-len(b))); err != nil {
+		return n, err
+	}
+	return n, nil
+}
@@ -3,6 +3,7 @@ package protocol
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
 	"strconv"
 )
@@ -55,3 +56,45 @@ func SplitHostPort(address string) (string, int, error) {
 	}
 	return host, port, nil
 }
+
+// ReadSocksAddr 从流中读取 SOCKS5 地址格式 [Type][Addr...][Port]，返回 host 与 port
+func ReadSocksAddr(r io.Reader) (string, int, error) {
+	typeBuf := make([]byte, 1)
+	if _, err := io.ReadFull(r, typeBuf); err != nil {
+		return "", 0, err
+	}
+
+	var host string
+	switch typeBuf[0] {
+	case 0x01: // IPv4
+		ipBuf := make([]byte, 4)
+		if _, err := io.ReadFull(r, ipBuf); err != nil {
+			return "", 0, err
+		}
+		host = net.IP(ipBuf).String()
+	case 0x03: // Domain
+		lenBuf := make([]byte, 1)
+		if _, err := io.ReadFull(r, lenBuf); err != nil {
+			return "", 0, err
+		}
+		domainBuf := make([]byte, int(lenBuf[0]))
+		if _, err := io.ReadFull(r, domainBuf); err != nil {
+			return "", 0, err
+		}
+		host = string(domainBuf)
+	case 0x04: // IPv6
+		ipBuf := make([]byte, 16)
+		if _, err := io.ReadFull(r, ipBuf); err != nil {
+			return "", 0, err
+		}
+		host = net.IP(ipBuf).String()
+	default:
+		return "", 0, fmt.Errorf("invalid socks address type: 0x%02x", typeBuf[0])
+	}
+
+	portBuf := make([]byte, 2)
+	if _, err := io.ReadFull(r, portBuf); err != nil {
+		return "", 0, err
+	}
+	return host, int(binary.BigEndian.Uint16(portBuf)), nil
+}
@@ -7,11 +7,29 @@ import (
 	"io"
 	"log"
 	"net"
+
+	"mandala/core/config"
+)
+
+// VLESS 指令
+const (
+	vlessCmdTCP = 0x01
+	vlessCmdUDP = 0x02
 )
 
 // BuildVlessPayload 构造 VLESS 握手包 (Version 0)
 func BuildVlessPayload(uuidStr, targetHost string, targetPort int) ([]byte, error) {
-	log.Printf("[Vless] 开始构造请求 -> %s:%d (UUID: %s)", targetHost, targetPort, uuidStr)
+	return buildVlessRequest(uuidStr, vlessCmdTCP, targetHost, targetPort)
+}
+
+// BuildVlessUDPPayload 构造 VLESS UDP 请求 (CMD 0x02)
+// 之后的每个数据报使用 [Length(2)][Payload] 封装
+func BuildVlessUDPPayload(uuidStr, targetHost string, targetPort int) ([]byte, error) {
+	return buildVlessRequest(uuidStr, vlessCmdUDP, targetHost, targetPort)
+}
+
+func buildVlessRequest(uuidStr string, cmd byte, targetHost string, targetPort int) ([]byte, error) {
+	log.Printf("[Vless] 开始构造请求 -> %s:%d (UUID: %s, CMD: 0x%02x)", targetHost, targetPort, uuidStr, cmd)
 	
 	uuid, err := ParseUUID(uuidStr) 
 	if err != nil {
@@ -24,7 +42,7 @@ func BuildVlessPayload(uuidStr, targetHost string, targetPort int) ([]byte, erro
 	buf.Write(uuid)     // UUID (16 bytes)
 	buf.WriteByte(0x00) // Addon Length (0)
 
-	buf.WriteByte(0x01) // Command (Connect TCP)
+	buf.WriteByte(cmd) // Command (0x01 TCP / 0x02 UDP)
 
 	// 写入端口 (Big Endian)
 	portBuf := make([]byte, 2)
@@ -103,3 +121,32 @@ func (vc *VlessConn) Read(b []byte) (int, error) {
 
 	return vc.Conn.Read(b)
 }
+
+func init() {
+	RegisterOutbound(func(cfg *config.OutboundConfig, dial DialFunc) (Outbound, error) {
+		return &streamOutbound{
+			dial: dial,
+			handshake: func(conn net.Conn, dest Destination) (net.Conn, error) {
+				payload, err := BuildVlessPayload(cfg.UUID, dest.Host, dest.Port)
+				if err != nil {
+					return nil, err
+				}
+				if _, err := writePayload(conn, payload); err != nil {
+					return nil, err
+				}
+				// 包装连接以剥离响应头
+				return NewVlessConn(conn), nil
+			},
+			udpHandshake: func(conn net.Conn, dest Destination) (net.Conn, error) {
+				payload, err := BuildVlessUDPPayload(cfg.UUID, dest.Host, dest.Port)
+				if err != nil {
+					return nil, err
+				}
+				if _, err := writePayload(conn, payload); err != nil {
+					return nil, err
+				}
+				return NewLengthPacketConn(NewVlessConn(conn)), nil
+			},
+		}, nil
+	}, "vless")
+}
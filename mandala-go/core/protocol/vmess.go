@@ -0,0 +1,512 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"log"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"mandala/core/config"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/sha3"
+)
+
+// VMess 协议常量 (AEAD 头部认证，alterId = 0)
+const (
+	vmessVersion = 1
+
+	vmessSecurityAES128GCM = 3
+	vmessSecurityChacha20  = 4
+	vmessSecurityNone      = 5
+
+	vmessOptChunkStream   = 0x01
+	vmessOptChunkMasking  = 0x04
+	vmessOptGlobalPadding = 0x08
+
+	vmessCmdTCP = 0x01
+	vmessCmdUDP = 0x02
+
+	vmessMaxPayload = 8192 - 16 - 2 - 64
+)
+
+// VMess 固定盐值
+const (
+	vmessCmdKeySalt           = "c48619fe-8f02-49e0-b9e9-edf763e17e21"
+	vmessKDFSalt              = "VMess AEAD KDF"
+	vmessKDFAuthIDKey         = "AES Auth ID Encryption"
+	vmessKDFHeaderLenKey      = "VMess Header AEAD Key_Length"
+	vmessKDFHeaderLenIV       = "VMess Header AEAD Nonce_Length"
+	vmessKDFHeaderKey         = "VMess Header AEAD Key"
+	vmessKDFHeaderIV          = "VMess Header AEAD Nonce"
+	vmessKDFRespHeaderLenKey  = "AEAD Resp Header Len Key"
+	vmessKDFRespHeaderLenIV   = "AEAD Resp Header Len IV"
+	vmessKDFRespHeaderBodyKey = "AEAD Resp Header Key"
+	vmessKDFRespHeaderBodyIV  = "AEAD Resp Header IV"
+)
+
+// vmessKDF 实现 VMess AEAD 的嵌套 HMAC-SHA256 密钥派生
+func vmessKDF(key []byte, path ...string) []byte {
+	creator := func() hash.Hash { return hmac.New(sha256.New, []byte(vmessKDFSalt)) }
+	for _, p := range path {
+		parent, value := creator, []byte(p)
+		creator = func() hash.Hash { return hmac.New(parent, value) }
+	}
+	h := creator()
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+func vmessKDF16(key []byte, path ...string) []byte {
+	return vmessKDF(key, path...)[:16]
+}
+
+// vmessCmdKey 由 UUID 计算指令密钥: MD5(UUID + 固定盐)
+func vmessCmdKey(uuid []byte) []byte {
+	h := md5.New()
+	h.Write(uuid)
+	h.Write([]byte(vmessCmdKeySalt))
+	return h.Sum(nil)
+}
+
+// vmessSecurityByName 将配置中的加密方式名称转换为协议值
+func vmessSecurityByName(name string) (byte, error) {
+	switch strings.ToLower(name) {
+	case "", "auto":
+		// 有 AES 硬件加速的平台优先使用 AES-GCM
+		if runtime.GOARCH == "amd64" || runtime.GOARCH == "arm64" || runtime.GOARCH == "s390x" {
+			return vmessSecurityAES128GCM, nil
+		}
+		return vmessSecurityChacha20, nil
+	case "aes-128-gcm":
+		return vmessSecurityAES128GCM, nil
+	case "chacha20-poly1305", "chacha20-ietf-poly1305":
+		return vmessSecurityChacha20, nil
+	case "none", "zero":
+		return vmessSecurityNone, nil
+	default:
+		return 0, fmt.Errorf("vmess unsupported security: %s", name)
+	}
+}
+
+// sealVmessAEADHeader 构造 AEAD 请求头
+// 结构: [AuthID(16)] [Seal(Len)(18)] [ConnectionNonce(8)] [Seal(Header)]
+func sealVmessAEADHeader(cmdKey, header []byte) ([]byte, error) {
+	random := make([]byte, 4+8)
+	if _, err := io.ReadFull(rand.Reader, random); err != nil {
+		return nil, err
+	}
+	authID, err := vmessAuthID(cmdKey, time.Now().Unix(), random[:4])
+	if err != nil {
+		return nil, err
+	}
+	return sealVmessHeader(cmdKey, header, authID, random[4:])
+}
+
+// vmessAuthID 计算 AuthID = AES(KDF(cmdKey), [Timestamp(8)][Random(4)][CRC32(4)])
+func vmessAuthID(cmdKey []byte, now int64, random []byte) ([]byte, error) {
+	authID := make([]byte, 16)
+	binary.BigEndian.PutUint64(authID, uint64(now))
+	copy(authID[8:12], random)
+	binary.BigEndian.PutUint32(authID[12:], crc32.ChecksumIEEE(authID[:12]))
+	block, err := aes.NewCipher(vmessKDF16(cmdKey, vmessKDFAuthIDKey))
+	if err != nil {
+		return nil, err
+	}
+	block.Encrypt(authID, authID)
+	return authID, nil
+}
+
+// sealVmessHeader 以给定的 AuthID 与连接随机数加密头部长度与头部
+func sealVmessHeader(cmdKey, header, authID, nonce []byte) ([]byte, error) {
+	lenBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(lenBuf, uint16(len(header)))
+	lenAEAD, err := newAESGCM(vmessKDF16(cmdKey, vmessKDFHeaderLenKey, string(authID), string(nonce)))
+	if err != nil {
+		return nil, err
+	}
+	sealedLen := lenAEAD.Seal(nil, vmessKDF(cmdKey, vmessKDFHeaderLenIV, string(authID), string(nonce))[:12], lenBuf, authID)
+
+	headerAEAD, err := newAESGCM(vmessKDF16(cmdKey, vmessKDFHeaderKey, string(authID), string(nonce)))
+	if err != nil {
+		return nil, err
+	}
+	sealedHeader := headerAEAD.Seal(nil, vmessKDF(cmdKey, vmessKDFHeaderIV, string(authID), string(nonce))[:12], header, authID)
+
+	var out bytes.Buffer
+	out.Write(authID)
+	out.Write(sealedLen)
+	out.Write(nonce)
+	out.Write(sealedHeader)
+	return out.Bytes(), nil
+}
+
+// HandshakeVmess 发送 VMess AEAD 请求头并返回负责数据分块加解密的连接
+func HandshakeVmess(conn net.Conn, uuidStr, security, targetHost string, targetPort int) (net.Conn, error) {
+	return handshakeVmess(conn, uuidStr, security, vmessCmdTCP, targetHost, targetPort)
+}
+
+// HandshakeVmessUDP 建立 VMess UDP 会话 (CMD 0x02)，每个数据分块即一个数据报
+func HandshakeVmessUDP(conn net.Conn, uuidStr, security, targetHost string, targetPort int) (net.Conn, error) {
+	return handshakeVmess(conn, uuidStr, security, vmessCmdUDP, targetHost, targetPort)
+}
+
+func handshakeVmess(conn net.Conn, uuidStr, security string, cmd byte, targetHost string, targetPort int) (net.Conn, error) {
+	log.Printf("[Vmess] 开始构造请求 -> %s:%d (Security: %s, CMD: 0x%02x)", targetHost, targetPort, security, cmd)
+
+	uuid, err := ParseUUID(uuidStr)
+	if err != nil {
+		log.Printf("[Vmess] UUID 解析错误: %v", err)
+		return nil, err
+	}
+	sec, err := vmessSecurityByName(security)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1. 随机生成数据密钥、IV 与响应认证字节
+	keys := make([]byte, 16+16+1+1)
+	if _, err := io.ReadFull(rand.Reader, keys); err != nil {
+		return nil, err
+	}
+	reqIV, reqKey := keys[:16], keys[16:32]
+	respV := keys[32]
+	padLen := int(keys[33] % 16)
+
+	option := byte(vmessOptChunkStream | vmessOptChunkMasking)
+	if sec != vmessSecurityNone {
+		option |= vmessOptGlobalPadding
+	}
+
+	// 2. 指令部分: [Ver][IV][Key][V][Opt][P<<4|Sec][Rsv][Cmd][Port][AddrType][Addr][Padding][FNV1a]
+	var header bytes.Buffer
+	header.WriteByte(vmessVersion)
+	header.Write(reqIV)
+	header.Write(reqKey)
+	header.WriteByte(respV)
+	header.WriteByte(option)
+	header.WriteByte(byte(padLen<<4) | sec)
+	header.WriteByte(0x00)
+	header.WriteByte(cmd)
+
+	portBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(portBuf, uint16(targetPort))
+	header.Write(portBuf)
+
+	// VMess 地址类型: 0x01=IPv4, 0x02=Domain, 0x03=IPv6
+	ip := net.ParseIP(targetHost)
+	if ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			header.WriteByte(0x01)
+			header.Write(ip4)
+		} else {
+			header.WriteByte(0x03)
+			header.Write(ip.To16())
+		}
+	} else {
+		if len(targetHost) > 255 {
+			return nil, fmt.Errorf("domain name too long: %s", targetHost)
+		}
+		header.WriteByte(0x02)
+		header.WriteByte(byte(len(targetHost)))
+		header.WriteString(targetHost)
+	}
+
+	if padLen > 0 {
+		padding := make([]byte, padLen)
+		if _, err := io.ReadFull(rand.Reader, padding); err != nil {
+			return nil, err
+		}
+		header.Write(padding)
+	}
+
+	fnvHash := fnv.New32a()
+	fnvHash.Write(header.Bytes())
+	header.Write(fnvHash.Sum(nil))
+
+	// 3. AEAD 封装并发送
+	sealed, err := sealVmessAEADHeader(vmessCmdKey(uuid), header.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(sealed); err != nil {
+		return nil, fmt.Errorf("vmess handshake write failed: %v", err)
+	}
+
+	respKeyHash := sha256.Sum256(reqKey)
+	respIVHash := sha256.Sum256(reqIV)
+
+	vc := &VmessConn{
+		Conn:    conn,
+		respV:   respV,
+		respKey: respKeyHash[:16],
+		respIV:  respIVHash[:16],
+	}
+	vc.writer, err = newVmessChunkCodec(sec, option, reqKey, reqIV)
+	if err != nil {
+		return nil, err
+	}
+	vc.reader, err = newVmessChunkCodec(sec, option, vc.respKey, vc.respIV)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[Vmess] 请求头发送完成")
+	return vc, nil
+}
+
+// vmessChunkCodec 处理单方向的数据分块: 长度混淆 (SHAKE128)、随机填充与 AEAD 加密
+type vmessChunkCodec struct {
+	aead    cipher.AEAD // 为 nil 表示 security=none
+	iv      []byte
+	count   uint16
+	shake   sha3.ShakeHash
+	padding bool
+}
+
+func newVmessChunkCodec(sec, option byte, key, iv []byte) (*vmessChunkCodec, error) {
+	c := &vmessChunkCodec{iv: iv}
+
+	switch sec {
+	case vmessSecurityAES128GCM:
+		aead, err := newAESGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		c.aead = aead
+	case vmessSecurityChacha20:
+		// ChaCha20 密钥: MD5(key) + MD5(MD5(key))
+		k1 := md5.Sum(key)
+		k2 := md5.Sum(k1[:])
+		aead, err := chacha20poly1305.New(append(k1[:], k2[:]...))
+		if err != nil {
+			return nil, err
+		}
+		c.aead = aead
+	}
+
+	if option&vmessOptChunkMasking != 0 {
+		c.shake = sha3.NewShake128()
+		c.shake.Write(iv)
+	}
+	c.padding = option&vmessOptGlobalPadding != 0 && c.aead != nil
+	return c, nil
+}
+
+// nextMask 从 SHAKE128 流中读取下一个 16 位掩码
+func (c *vmessChunkCodec) nextMask() uint16 {
+	if c.shake == nil {
+		return 0
+	}
+	b := make([]byte, 2)
+	c.shake.Read(b)
+	return binary.BigEndian.Uint16(b)
+}
+
+func (c *vmessChunkCodec) nextPadding() int {
+	if !c.padding {
+		return 0
+	}
+	return int(c.nextMask() % 64)
+}
+
+func (c *vmessChunkCodec) nonce() []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint16(nonce, c.count)
+	copy(nonce[2:], c.iv[2:12])
+	c.count++
+	return nonce
+}
+
+func (c *vmessChunkCodec) overhead() int {
+	if c.aead == nil {
+		return 0
+	}
+	return c.aead.Overhead()
+}
+
+// seal 将一块明文编码为 [Len(2)] [Seal(Payload)] [Padding]
+func (c *vmessChunkCodec) seal(out, payload []byte) ([]byte, error) {
+	padLen := c.nextPadding()
+	size := len(payload) + c.overhead() + padLen
+
+	lenBuf := make([]byte, 2)
+	binary.BigEndian.PutUint16(lenBuf, uint16(size)^c.nextMask())
+	out = append(out, lenBuf...)
+
+	if c.aead != nil {
+		out = c.aead.Seal(out, c.nonce(), payload, nil)
+	} else {
+		out = append(out, payload...)
+	}
+
+	if padLen > 0 {
+		padding := make([]byte, padLen)
+		if _, err := io.ReadFull(rand.Reader, padding); err != nil {
+			return nil, err
+		}
+		out = append(out, padding...)
+	}
+	return out, nil
+}
+
+// open 从流中读取一个分块并返回解密后的明文
+func (c *vmessChunkCodec) open(r io.Reader) ([]byte, error) {
+	padLen := c.nextPadding()
+
+	lenBuf := make([]byte, 2)
+	if _, err := io.ReadFull(r, lenBuf); err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint16(lenBuf) ^ c.nextMask())
+	if size < c.overhead()+padLen {
+		return nil, errors.New("vmess invalid chunk size")
+	}
+
+	chunk := make([]byte, size)
+	if _, err := io.ReadFull(r, chunk); err != nil {
+		return nil, err
+	}
+	chunk = chunk[:size-padLen]
+
+	if c.aead == nil {
+		return chunk, nil
+	}
+	plain, err := c.aead.Open(chunk[:0], c.nonce(), chunk, nil)
+	if err != nil {
+		return nil, errors.New("vmess chunk authentication failed")
+	}
+	return plain, nil
+}
+
+// VmessConn 包装 VMess 数据流，首次读取时解析 AEAD 响应头
+type VmessConn struct {
+	net.Conn
+	respV   byte
+	respKey []byte
+	respIV  []byte
+
+	writeMu sync.Mutex
+	writer  *vmessChunkCodec
+
+	reader         *vmessChunkCodec
+	headerReceived bool
+	leftover       []byte
+}
+
+func (vc *VmessConn) Write(b []byte) (int, error) {
+	vc.writeMu.Lock()
+	defer vc.writeMu.Unlock()
+
+	var out []byte
+	var err error
+	for p := b; len(p) > 0; {
+		n := len(p)
+		if n > vmessMaxPayload {
+			n = vmessMaxPayload
+		}
+		if out, err = vc.writer.seal(out, p[:n]); err != nil {
+			return 0, err
+		}
+		p = p[n:]
+	}
+
+	if _, err := vc.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (vc *VmessConn) Read(b []byte) (int, error) {
+	if len(vc.leftover) > 0 {
+		n := copy(b, vc.leftover)
+		vc.leftover = vc.leftover[n:]
+		return n, nil
+	}
+
+	if !vc.headerReceived {
+		if err := vc.readResponseHeader(); err != nil {
+			return 0, err
+		}
+		vc.headerReceived = true
+	}
+
+	payload, err := vc.reader.open(vc.Conn)
+	if err != nil {
+		return 0, err
+	}
+	// 空分块表示服务端结束发送
+	if len(payload) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(b, payload)
+	if n < len(payload) {
+		vc.leftover = payload[n:]
+	}
+	return n, nil
+}
+
+// readResponseHeader 解密 AEAD 响应头: [Seal(Len)(18)] [Seal(Header)]
+func (vc *VmessConn) readResponseHeader() error {
+	lenAEAD, err := newAESGCM(vmessKDF16(vc.respKey, vmessKDFRespHeaderLenKey))
+	if err != nil {
+		return err
+	}
+	lenBuf := make([]byte, 2+lenAEAD.Overhead())
+	if _, err := io.ReadFull(vc.Conn, lenBuf); err != nil {
+		return err
+	}
+	if _, err := lenAEAD.Open(lenBuf[:0], vmessKDF(vc.respIV, vmessKDFRespHeaderLenIV)[:12], lenBuf, nil); err != nil {
+		return errors.New("vmess response header length authentication failed")
+	}
+	size := int(binary.BigEndian.Uint16(lenBuf[:2]))
+
+	headerAEAD, err := newAESGCM(vmessKDF16(vc.respKey, vmessKDFRespHeaderBodyKey))
+	if err != nil {
+		return err
+	}
+	header := make([]byte, size+headerAEAD.Overhead())
+	if _, err := io.ReadFull(vc.Conn, header); err != nil {
+		return err
+	}
+	if _, err := headerAEAD.Open(header[:0], vmessKDF(vc.respIV, vmessKDFRespHeaderBodyIV)[:12], header, nil); err != nil {
+		return errors.New("vmess response header authentication failed")
+	}
+
+	if size < 4 || header[0] != vc.respV {
+		return errors.New("vmess unexpected response header")
+	}
+	log.Printf("[Vmess] 响应头校验成功，进入数据传输阶段")
+	return nil
+}
+
+func init() {
+	RegisterOutbound(func(cfg *config.OutboundConfig, dial DialFunc) (Outbound, error) {
+		return &streamOutbound{
+			dial: dial,
+			handshake: func(conn net.Conn, dest Destination) (net.Conn, error) {
+				return HandshakeVmess(conn, cfg.UUID, cfg.Security, dest.Host, dest.Port)
+			},
+			udpHandshake: func(conn net.Conn, dest Destination) (net.Conn, error) {
+				return HandshakeVmessUDP(conn, cfg.UUID, cfg.Security, dest.Host, dest.Port)
+			},
+		}, nil
+	}, "vmess")
+}
@@ -0,0 +1,251 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// 以下期望值由独立实现 (Python hashlib/hmac 嵌套 HMAC-SHA256、OpenSSL AES 与按 NIST SP 800-38D 编写的 GCM)
+// 按 v2ray-core proxy/vmess/aead 的算法计算
+const vmessTestUUID = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func vmessTestCmdKey(t *testing.T) []byte {
+	uuid, err := ParseUUID(vmessTestUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vmessCmdKey(uuid)
+}
+
+func TestVmessKDF(t *testing.T) {
+	if got := vmessTestCmdKey(t); !bytes.Equal(got, mustHex(t, "b50d916ac0cec067981af8e5f38a758f")) {
+		t.Errorf("cmd key = %x", got)
+	}
+	// v2ray-core aead 测试中使用的密钥与路径
+	got := vmessKDF([]byte("Demo Key for Auth ID Test"), "Demo Path for Auth ID Test")
+	if want := mustHex(t, "66e41ad47fa745fbfd1e97325e93dbf4a04daac03e50fdf3052da7136662dfe1"); !bytes.Equal(got, want) {
+		t.Errorf("kdf = %x, want %x", got, want)
+	}
+	// 多级路径，末级超过 HMAC 块长度
+	got = vmessKDF([]byte("key"), "a", "bb", string(bytes.Repeat([]byte("c"), 100)))
+	if want := mustHex(t, "eeb678f2438c1461ef6d434dcf0e7a2169286bdf5907ac5aef8743a981ec3a78"); !bytes.Equal(got, want) {
+		t.Errorf("nested kdf = %x, want %x", got, want)
+	}
+}
+
+func TestVmessAuthIDAndHeaderSeal(t *testing.T) {
+	cmdKey := vmessTestCmdKey(t)
+	authID, err := vmessAuthID(cmdKey, 1700000000, []byte{1, 2, 3, 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := mustHex(t, "4774fe5cc901ea4f81f2159909767a36"); !bytes.Equal(authID, want) {
+		t.Fatalf("auth id = %x, want %x", authID, want)
+	}
+
+	sealed, err := sealVmessHeader(cmdKey, []byte("Test Header"), authID, []byte{1, 2, 3, 4, 5, 6, 7, 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := mustHex(t, "4774fe5cc901ea4f81f2159909767a36f89b43f686f6dcb564f5d404579c2393"+
+		"7b2a010203040506070836540572bb762c33d6860c797a76bc04275a1fd3145ca09d37e04c")
+	if !bytes.Equal(sealed, want) {
+		t.Fatalf("sealed header = %x, want %x", sealed, want)
+	}
+}
+
+func TestVmessChunkMasking(t *testing.T) {
+	key, iv := make([]byte, 16), make([]byte, 16)
+	for i := range key {
+		key[i], iv[i] = byte(i), byte(16+i)
+	}
+	c, err := newVmessChunkCodec(vmessSecurityAES128GCM, vmessOptChunkStream|vmessOptChunkMasking, key, iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.seal(nil, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := mustHex(t, "fc84a316c4d586054e854b4a74fc9040de5bd5eb74d177"); !bytes.Equal(got, want) {
+		t.Fatalf("chunk = %x, want %x", got, want)
+	}
+}
+
+// vmessTestRequest 是测试服务端解析出的请求头
+type vmessTestRequest struct {
+	iv, key  []byte
+	respV    byte
+	option   byte
+	security byte
+	cmd      byte
+	host     []byte
+	port     int
+}
+
+// serveVmessTest 按 VMess AEAD 服务端流程解析请求头，回送响应头后将收到的数据分块原样返回
+func serveVmessTest(conn net.Conn, cmdKey []byte, got chan<- *vmessTestRequest) error {
+	defer conn.Close()
+
+	authID := make([]byte, 16)
+	if _, err := io.ReadFull(conn, authID); err != nil {
+		return err
+	}
+	block, _ := aes.NewCipher(vmessKDF16(cmdKey, "AES Auth ID Encryption"))
+	plain := make([]byte, 16)
+	block.Decrypt(plain, authID)
+	if crc32.ChecksumIEEE(plain[:12]) != binary.BigEndian.Uint32(plain[12:]) {
+		return errors.New("auth id crc mismatch")
+	}
+	if d := time.Since(time.Unix(int64(binary.BigEndian.Uint64(plain)), 0)); d > 2*time.Minute || d < -2*time.Minute {
+		return errors.New("auth id timestamp out of range")
+	}
+
+	sealedLen := make([]byte, 18+8)
+	if _, err := io.ReadFull(conn, sealedLen); err != nil {
+		return err
+	}
+	nonce := sealedLen[18:]
+	kdf := func(label string) []byte { return vmessKDF(cmdKey, label, string(authID), string(nonce)) }
+	lenAEAD, _ := newAESGCM(kdf("VMess Header AEAD Key_Length")[:16])
+	lenBuf, err := lenAEAD.Open(nil, kdf("VMess Header AEAD Nonce_Length")[:12], sealedLen[:18], authID)
+	if err != nil {
+		return err
+	}
+	sealed := make([]byte, int(binary.BigEndian.Uint16(lenBuf))+16)
+	if _, err := io.ReadFull(conn, sealed); err != nil {
+		return err
+	}
+	headerAEAD, _ := newAESGCM(kdf("VMess Header AEAD Key")[:16])
+	header, err := headerAEAD.Open(nil, kdf("VMess Header AEAD Nonce")[:12], sealed, authID)
+	if err != nil {
+		return err
+	}
+
+	h := fnv.New32a()
+	h.Write(header[:len(header)-4])
+	if !bytes.Equal(h.Sum(nil), header[len(header)-4:]) {
+		return errors.New("header fnv mismatch")
+	}
+	req := &vmessTestRequest{
+		iv: header[1:17], key: header[17:33], respV: header[33], option: header[34],
+		security: header[35] & 0x0f, cmd: header[37], port: int(binary.BigEndian.Uint16(header[38:40])),
+	}
+	if header[0] != 1 || header[40] != 0x02 {
+		return errors.New("unexpected version or address type")
+	}
+	req.host = header[42 : 42+int(header[41])]
+	got <- req
+
+	respKey := sha256.Sum256(req.key)
+	respIV := sha256.Sum256(req.iv)
+	respLenAEAD, _ := newAESGCM(vmessKDF16(respKey[:16], "AEAD Resp Header Len Key"))
+	respAEAD, _ := newAESGCM(vmessKDF16(respKey[:16], "AEAD Resp Header Key"))
+	resp := []byte{req.respV, 0, 0, 0}
+	out := respLenAEAD.Seal(nil, vmessKDF(respIV[:16], "AEAD Resp Header Len IV")[:12], []byte{0, byte(len(resp))}, nil)
+	out = respAEAD.Seal(out, vmessKDF(respIV[:16], "AEAD Resp Header IV")[:12], resp, nil)
+	if _, err := conn.Write(out); err != nil {
+		return err
+	}
+
+	reader, err := newVmessChunkCodec(req.security, req.option, req.key, req.iv)
+	if err != nil {
+		return err
+	}
+	writer, err := newVmessChunkCodec(req.security, req.option, respKey[:16], respIV[:16])
+	if err != nil {
+		return err
+	}
+	for {
+		payload, err := reader.open(conn)
+		if err != nil {
+			return err
+		}
+		chunk, err := writer.seal(nil, payload)
+		if err != nil {
+			return err
+		}
+		if _, err := conn.Write(chunk); err != nil {
+			return err
+		}
+	}
+}
+
+func TestVmessRoundTrip(t *testing.T) {
+	cmdKey := vmessTestCmdKey(t)
+	for _, c := range []struct {
+		security string
+		want     byte
+		udp      bool
+	}{
+		{"aes-128-gcm", vmessSecurityAES128GCM, false},
+		{"chacha20-poly1305", vmessSecurityChacha20, false},
+		{"none", vmessSecurityNone, false},
+		{"aes-128-gcm", vmessSecurityAES128GCM, true},
+	} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(chan *vmessTestRequest, 1)
+		go func() {
+			if conn, err := ln.Accept(); err == nil {
+				serveVmessTest(conn, cmdKey, got)
+			}
+		}()
+
+		raw, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw.SetDeadline(time.Now().Add(5 * time.Second))
+		handshake := HandshakeVmess
+		if c.udp {
+			handshake = HandshakeVmessUDP
+		}
+		conn, err := handshake(raw, vmessTestUUID, c.security, "example.com", 443)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// 超过单个分块上限的数据会被拆分为多个分块
+		data := bytes.Repeat([]byte("0123456789abcdef"), 2000)
+		if _, err := conn.Write(data); err != nil {
+			t.Fatalf("%s: write: %v", c.security, err)
+		}
+		echo := make([]byte, len(data))
+		if _, err := io.ReadFull(conn, echo); err != nil || !bytes.Equal(echo, data) {
+			t.Fatalf("%s: echo mismatch: %v", c.security, err)
+		}
+
+		req := <-got
+		wantCmd := byte(vmessCmdTCP)
+		if c.udp {
+			wantCmd = vmessCmdUDP
+		}
+		if req.security != c.want || req.cmd != wantCmd || string(req.host) != "example.com" || req.port != 443 {
+			t.Errorf("%s: request = sec %d cmd %d %s:%d", c.security, req.security, req.cmd, req.host, req.port)
+		}
+		conn.Close()
+		ln.Close()
+	}
+}
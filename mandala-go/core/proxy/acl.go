@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// accessList 基于来源地址的访问控制
+// 规则: 命中 deny 则拒绝；allow 非空时必须命中 allow 才放行
+type accessList struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func newAccessList(allow, deny []string) (*accessList, error) {
+	a := &accessList{}
+	var err error
+	if a.allow, err = parseCIDRs(allow); err != nil {
+		return nil, err
+	}
+	if a.deny, err = parseCIDRs(deny); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// parseCIDRs 解析地址段列表，单个 IP 视为 /32 或 /128
+func parseCIDRs(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip: %s", item)
+			}
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr: %s", item)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// Allowed 判断来源地址是否允许访问
+func (a *accessList) Allowed(addr net.Addr) bool {
+	if a == nil {
+		return true
+	}
+	var ip net.IP
+	switch v := addr.(type) {
+	case *net.TCPAddr:
+		ip = v.IP
+	case *net.UDPAddr:
+		ip = v.IP
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return false
+		}
+		ip = net.ParseIP(host)
+	}
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range a.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(a.allow) == 0 {
+		return true
+	}
+	for _, n := range a.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
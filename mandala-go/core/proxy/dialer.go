@@ -9,11 +9,13 @@ import (
 	"math/rand"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"mandala/core/config"
+	"mandala/core/protocol"
 
 	"github.com/coder/websocket"
 	"github.com/miekg/dns"
@@ -30,8 +32,38 @@ var (
 	echCacheMutex sync.RWMutex
 )
 
+// ECHResolver 用于查询 ECH 配置 (HTTPS 记录) 的 DNS 解析器
+type ECHResolver interface {
+	Exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error)
+}
+
+var (
+	echResolver      ECHResolver
+	echResolverMutex sync.RWMutex
+)
+
+// SetECHResolver 设置节点未指定 ECHDoHURL 时使用的解析器，传入 nil 恢复默认 DoH
+func SetECHResolver(r ECHResolver) {
+	echResolverMutex.Lock()
+	echResolver = r
+	echResolverMutex.Unlock()
+}
+
+// clearECHResolver 在 r 仍为当前解析器时将其移除，避免停止一个实例时清掉另一实例设置的解析器
+func clearECHResolver(r ECHResolver) {
+	echResolverMutex.Lock()
+	if echResolver == r {
+		echResolver = nil
+	}
+	echResolverMutex.Unlock()
+}
+
+// echLookupKey 标记正在进行的 ECH 查询，避免解析器经由需要 ECH 的出站时递归查询
+type echLookupKey struct{}
+
 type Dialer struct {
 	Config *config.OutboundConfig
+	Detour protocol.Outbound // 前置出站，非 nil 时到服务器的 TCP 连接经由它建立 (代理链)
 }
 
 func NewDialer(cfg *config.OutboundConfig) *Dialer {
@@ -40,9 +72,14 @@ func NewDialer(cfg *config.OutboundConfig) *Dialer {
 
 // Dial 主入口：实现了 H2 -> H1 的退回机制
 func (d *Dialer) Dial() (net.Conn, error) {
+	return d.DialContext(context.Background())
+}
+
+// DialContext 适配 protocol.DialFunc，供统一出站接口使用
+func (d *Dialer) DialContext(ctx context.Context) (net.Conn, error) {
 	// 尝试 1: 默认模式 (允许 h2，指纹最真实)
 	// false 表示不强制移除 h2
-	conn, negotiated, err := d.handshake(false)
+	conn, negotiated, err := d.handshake(ctx, false)
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +93,7 @@ func (d *Dialer) Dial() (net.Conn, error) {
 
 		// 尝试 2: 退回模式 (强制 http/1.1)
 		// true 表示强制移除 h2
-		conn, negotiated, err = d.handshake(true)
+		conn, negotiated, err = d.handshake(ctx, true)
 		if err != nil {
 			return nil, fmt.Errorf("fallback handshake failed: %v", err)
 		}
@@ -72,13 +109,35 @@ func (d *Dialer) Dial() (net.Conn, error) {
 	return conn, nil
 }
 
+// NewOutbound 根据节点配置创建出站实例 (传输层由 Dialer 负责)
+func NewOutbound(cfg *config.OutboundConfig) (protocol.Outbound, error) {
+	return protocol.NewOutbound(cfg, NewDialer(cfg).DialContext)
+}
+
+// NewOutboundWithDetour 创建经由 detour 连接服务器的出站实例
+func NewOutboundWithDetour(cfg *config.OutboundConfig, detour protocol.Outbound) (protocol.Outbound, error) {
+	return protocol.NewOutbound(cfg, (&Dialer{Config: cfg, Detour: detour}).DialContext)
+}
+
+// dialServer 建立到服务器的底层 TCP 连接，配置了 Detour 时通过前置出站建立
+// ctx 取消 (如 TUN 连接关闭、测速超时) 时中止拨号
+func (d *Dialer) dialServer(ctx context.Context) (net.Conn, error) {
+	if d.Detour != nil {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		return d.Detour.DialTCP(ctx, protocol.Destination{Host: d.Config.Server, Port: d.Config.ServerPort})
+	}
+	targetAddr := net.JoinHostPort(d.Config.Server, strconv.Itoa(d.Config.ServerPort))
+	// 受保护的套接字不会进入 TUN，App 自身无需排除在 VPN 之外
+	return protocol.NewProtectedDialer(5*time.Second).DialContext(ctx, "tcp", targetAddr)
+}
+
 // handshake 执行底层的 TCP 连接和 TLS 握手
 // forceH1: 是否强制只使用 http/1.1 (剔除 h2)
 // 返回: 连接对象, 协商出的协议(ALPN), 错误
-func (d *Dialer) handshake(forceH1 bool) (net.Conn, string, error) {
-	// 1. 基础 TCP 连接
-	targetAddr := fmt.Sprintf("%s:%d", d.Config.Server, d.Config.ServerPort)
-	conn, err := net.DialTimeout("tcp", targetAddr, 5*time.Second)
+func (d *Dialer) handshake(ctx context.Context, forceH1 bool) (net.Conn, string, error) {
+	// 1. 基础 TCP 连接 (直连或经由前置出站)
+	conn, err := d.dialServer(ctx)
 	if err != nil {
 		return nil, "", err
 	}
@@ -91,7 +150,7 @@ func (d *Dialer) handshake(forceH1 bool) (net.Conn, string, error) {
 	// 2. TLS/ECH 逻辑
 	var echConfigList []byte
 	if d.Config.TLS.EnableECH {
-		echConfigList = d.getECHConfig()
+		echConfigList = d.getECHConfig(ctx)
 	}
 
 	// ECH 必须配合 TLS 1.3
@@ -153,7 +212,7 @@ func (d *Dialer) handshake(forceH1 bool) (net.Conn, string, error) {
 		return nil, "", fmt.Errorf("preset error: %v", err)
 	}
 
-	if err := uConn.Handshake(); err != nil {
+	if err := uConn.HandshakeContext(ctx); err != nil {
 		conn.Close()
 		return nil, "", fmt.Errorf("handshake failed: %v", err)
 	}
@@ -163,7 +222,8 @@ func (d *Dialer) handshake(forceH1 bool) (net.Conn, string, error) {
 }
 
 // getECHConfig 封装 ECH 获取与缓存逻辑
-func (d *Dialer) getECHConfig() []byte {
+// 节点指定了 ECHDoHURL 时使用该 DoH，否则优先使用 SetECHResolver 设置的解析器
+func (d *Dialer) getECHConfig(ctx context.Context) []byte {
 	queryDomain := d.Config.TLS.ECHPublicName
 	if queryDomain == "" {
 		queryDomain = d.Config.TLS.ServerName
@@ -178,15 +238,25 @@ func (d *Dialer) getECHConfig() []byte {
 		return cached
 	}
 
+	echResolverMutex.RLock()
+	resolver := echResolver
+	echResolverMutex.RUnlock()
+
 	dohURL := d.Config.TLS.ECHDoHURL
-	if dohURL == "" {
+	if dohURL == "" && (resolver == nil || ctx.Value(echLookupKey{}) != nil) {
 		dohURL = "https://1.1.1.1/dns-query"
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	ctx, cancel := context.WithTimeout(context.WithValue(ctx, echLookupKey{}, true), 4*time.Second)
 	defer cancel()
-	
-	configs, err := resolveECHConfig(ctx, dohURL, queryDomain)
+
+	var configs []byte
+	var err error
+	if dohURL != "" {
+		configs, err = resolveECHConfig(ctx, dohURL, queryDomain)
+	} else {
+		configs, err = lookupECHConfig(ctx, resolver, queryDomain)
+	}
 	if err == nil && len(configs) > 0 {
 		echCacheMutex.Lock()
 		echCache[queryDomain] = configs
@@ -256,6 +326,18 @@ func (d *Dialer) upgradeWebsocket(conn net.Conn) (net.Conn, error) {
 	return websocket.NetConn(context.Background(), wsConn, websocket.MessageBinary), nil
 }
 
+// lookupECHConfig 通过解析器查询域名的 HTTPS 记录并提取 ECH 配置
+func lookupECHConfig(ctx context.Context, resolver ECHResolver, domain string) ([]byte, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(domain), dns.TypeHTTPS)
+	msg.RecursionDesired = true
+	resp, err := resolver.Exchange(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	return extractECHConfig(resp)
+}
+
 // resolveECHConfig (保持不变)
 func resolveECHConfig(ctx context.Context, dohURL string, domain string) ([]byte, error) {
 	msg := new(dns.Msg)
@@ -282,8 +364,9 @@ func resolveECHConfig(ctx context.Context, dohURL string, domain string) ([]byte
 
 	client := &http.Client{
 		Transport: &http.Transport{
-			DisableKeepAlives: true,
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			DialContext:           protocol.NewProtectedDialer(5 * time.Second).DialContext,
+			DisableKeepAlives:     true,
+			TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
 			ResponseHeaderTimeout: 5 * time.Second,
 		},
 	}
@@ -307,7 +390,11 @@ func resolveECHConfig(ctx context.Context, dohURL string, domain string) ([]byte
 	if err := respMsg.Unpack(body); err != nil {
 		return nil, err
 	}
+	return extractECHConfig(respMsg)
+}
 
+// extractECHConfig 从 HTTPS 记录应答中提取 ECH 配置
+func extractECHConfig(respMsg *dns.Msg) ([]byte, error) {
 	for _, ans := range respMsg.Answer {
 		if https, ok := ans.(*dns.HTTPS); ok {
 			for _, val := range https.Value {
@@ -1,26 +1,51 @@
 package proxy
 
 import (
+	"bytes"
+	"context"
+	"crypto/subtle"
 	"io"
 	"log"
 	"net"
-	"strings"
-	"time"
 
 	"mandala/core/config"
 	"mandala/core/protocol"
+	"mandala/core/route"
+)
+
+// SOCKS5 认证方法、指令与应答码
+const (
+	socksMethodNoAuth       = 0x00
+	socksMethodUserPass     = 0x02
+	socksMethodNoAcceptable = 0xFF
+
+	socksCmdConnect      = 0x01
+	socksCmdUDPAssociate = 0x03
+
+	socksRepSuccess          = 0x00
+	socksRepGeneralFailure   = 0x01
+	socksRepNotAllowed       = 0x02
+	socksRepHostUnreachable  = 0x04
+	socksRepCmdNotSupported  = 0x07
+	socksRepAddrNotSupported = 0x08
 )
 
 // Handler 处理单个本地连接
 type Handler struct {
-	Config *config.OutboundConfig
+	Config   *config.OutboundConfig
+	Outbound protocol.Outbound // 为 nil 时按 Config 临时创建
+	Router   *route.Router     // 为 nil 时全部走 Outbound
+
+	// 入站认证 (RFC 1929)，Username 为空表示无需认证
+	Username string
+	Password string
 }
 
 // HandleConnection 处理 SOCKS5 请求并转发
 func (h *Handler) HandleConnection(localConn net.Conn) {
 	defer localConn.Close()
 
-	// 1. SOCKS5 握手 (无需认证)
+	// 1. SOCKS5 握手 (方法协商与认证)
 	buf := make([]byte, 262)
 	if _, err := io.ReadFull(localConn, buf[:2]); err != nil {
 		return
@@ -28,159 +53,140 @@ func (h *Handler) HandleConnection(localConn net.Conn) {
 	if buf[0] != 0x05 {
 		return
 	}
-	localConn.Write([]byte{0x05, 0x00})
-
-	// 2. 读取客户端请求
-	n, err := io.ReadFull(localConn, buf[:4])
-	if err != nil || n < 4 {
+	if !h.negotiateAuth(localConn, buf) {
 		return
 	}
 
+	// 2. 读取客户端请求: [VER][CMD][RSV][ATYP][ADDR][PORT]
+	if _, err := io.ReadFull(localConn, buf[:3]); err != nil {
+		return
+	}
 	cmd := buf[1]
-	atyp := buf[3]
-	var targetHost string
-	var targetPort int
 
-	if cmd != 0x01 {
+	targetHost, targetPort, err := protocol.ReadSocksAddr(localConn)
+	if err != nil {
+		localConn.Write(socksReply(socksRepAddrNotSupported, nil))
 		return
 	}
 
-	// 解析目标地址
-	switch atyp {
-	case 0x01: // IPv4
-		ipBuf := make([]byte, 4)
-		if _, err := io.ReadFull(localConn, ipBuf); err != nil {
-			return
-		}
-		targetHost = net.IP(ipBuf).String()
-	case 0x03: // Domain
-		lenBuf := make([]byte, 1)
-		if _, err := io.ReadFull(localConn, lenBuf); err != nil {
-			return
-		}
-		domainLen := int(lenBuf[0])
-		domainBuf := make([]byte, domainLen)
-		if _, err := io.ReadFull(localConn, domainBuf); err != nil {
-			return
-		}
-		targetHost = string(domainBuf)
-	case 0x04: // IPv6
-		ipBuf := make([]byte, 16)
-		if _, err := io.ReadFull(localConn, ipBuf); err != nil {
-			return
-		}
-		targetHost = net.IP(ipBuf).String()
+	switch cmd {
+	case socksCmdConnect:
+	case socksCmdUDPAssociate:
+		h.handleUDPAssociate(localConn)
+		return
 	default:
+		localConn.Write(socksReply(socksRepCmdNotSupported, nil))
 		return
 	}
 
-	portBuf := make([]byte, 2)
-	if _, err := io.ReadFull(localConn, portBuf); err != nil {
+	// 3. 按路由选择出站
+	dest := protocol.Destination{Host: targetHost, Port: targetPort}
+	outbound, err := h.pick(route.InboundSocks5, "tcp", dest)
+	if err != nil {
+		rep := byte(socksRepGeneralFailure)
+		if err == route.ErrBlocked {
+			rep = socksRepNotAllowed
+		} else {
+			log.Printf("[Proxy] %v", err)
+		}
+		localConn.Write(socksReply(rep, nil))
 		return
 	}
-	targetPort = int(portBuf[0])<<8 | int(portBuf[1])
 
-	// 3. 连接远程代理服务器
-	dialer := NewDialer(h.Config)
-	remoteConn, err := dialer.Dial()
+	// 4. 通过出站协议连接目标 (包含拨号与握手)
+	remoteConn, err := outbound.DialTCP(context.Background(), dest)
 	if err != nil {
-		log.Printf("[Proxy] Dial remote failed: %v", err)
-		localConn.Write([]byte{0x05, 0x04, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+		log.Printf("[Proxy] Dial %s failed: %v", dest, err)
+		localConn.Write(socksReply(socksRepHostUnreachable, nil))
 		return
 	}
 	defer remoteConn.Close()
 
-	// 4. 发送协议头 (握手)
-	proxyType := strings.ToLower(h.Config.Type)
-	isVless := false
-
-	switch proxyType {
-	case "mandala":
-		client := protocol.NewMandalaClient(h.Config.Username, h.Config.Password)
-		// [修改] 传入 Noise 配置
-		payload, err := client.BuildHandshakePayload(targetHost, targetPort, h.Config.Settings.Noise)
-		if err != nil {
-			log.Printf("[Mandala] Build payload failed: %v", err)
-			return
-		}
-		if _, err := remoteConn.Write(payload); err != nil {
-			log.Printf("[Mandala] Handshake write failed: %v", err)
-			return
-		}
-
-	case "trojan":
-		payload, err := protocol.BuildTrojanPayload(h.Config.Password, targetHost, targetPort)
-		if err != nil {
-			log.Printf("[Trojan] Build payload failed: %v", err)
-			return
-		}
-		if _, err := remoteConn.Write(payload); err != nil {
-			log.Printf("[Trojan] Handshake write failed: %v", err)
-			return
-		}
-
-	case "vless":
-		payload, err := protocol.BuildVlessPayload(h.Config.UUID, targetHost, targetPort)
-		if err != nil {
-			log.Printf("[Vless] Build payload failed: %v", err)
-			return
-		}
-		if _, err := remoteConn.Write(payload); err != nil {
-			log.Printf("[Vless] Handshake write failed: %v", err)
-			return
-		}
-		isVless = true
-
-	// [新增] Shadowsocks 支持
-	case "shadowsocks":
-		payload, err := protocol.BuildShadowsocksPayload(targetHost, targetPort)
-		if err != nil {
-			log.Printf("[Shadowsocks] Build payload failed: %v", err)
-			return
-		}
-		if _, err := remoteConn.Write(payload); err != nil {
-			log.Printf("[Shadowsocks] Handshake write failed: %v", err)
-			return
-		}
-
-	// [新增] SOCKS5 支持 (含认证)
-	case "socks", "socks5":
-		err := protocol.HandshakeSocks5(remoteConn, h.Config.Username, h.Config.Password, targetHost, targetPort)
-		if err != nil {
-			log.Printf("[Socks5] Handshake failed: %v", err)
-			return
-		}
-
-	default:
-		log.Println("[Proxy] Protocol not implemented:", proxyType)
+	// 5. 告知本地客户端连接成功
+	if _, err := localConn.Write(socksReply(socksRepSuccess, nil)); err != nil {
 		return
 	}
 
-	// 如果是 VLESS，包装连接以剥离响应头
-	if isVless {
-		remoteConn = protocol.NewVlessConn(remoteConn)
+	// 6. 双向转发
+	relay(localConn, remoteConn)
+}
+
+// outbound 返回共享的出站实例，未设置时按 Config 临时创建
+func (h *Handler) outbound() (protocol.Outbound, error) {
+	if h.Outbound != nil {
+		return h.Outbound, nil
 	}
+	return NewOutbound(h.Config)
+}
 
-	// 5. 告知本地客户端连接成功
-	if _, err := localConn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0}); err != nil {
-		return
+// pick 按路由规则为 dest 选择出站，未配置路由时使用共享出站
+func (h *Handler) pick(inbound, network string, dest protocol.Destination) (protocol.Outbound, error) {
+	if h.Router == nil {
+		return h.outbound()
 	}
+	return h.Router.Pick(route.NewMetadata(network, inbound, dest))
+}
 
-	// 6. 双向转发
-	localConn.SetDeadline(time.Time{})
-	remoteConn.SetDeadline(time.Time{})
+// negotiateAuth 读取客户端方法列表并完成认证，buf[1] 为 NMETHODS
+// 配置了用户名时只接受 0x02 (用户名/密码)，否则使用 0x00 (无需认证)
+func (h *Handler) negotiateAuth(localConn net.Conn, buf []byte) bool {
+	methods := buf[:int(buf[1])]
+	if _, err := io.ReadFull(localConn, methods); err != nil {
+		return false
+	}
 
-	errChan := make(chan error, 2)
+	want := byte(socksMethodNoAuth)
+	if h.Username != "" {
+		want = socksMethodUserPass
+	}
+	if bytes.IndexByte(methods, want) < 0 {
+		localConn.Write([]byte{0x05, socksMethodNoAcceptable})
+		return false
+	}
+	if _, err := localConn.Write([]byte{0x05, want}); err != nil {
+		return false
+	}
+	if want == socksMethodNoAuth {
+		return true
+	}
 
-	go func() {
-		_, err := io.Copy(remoteConn, localConn)
-		errChan <- err
-	}()
+	// RFC 1929: [VER(0x01)][ULEN][UNAME][PLEN][PASSWD]
+	if _, err := io.ReadFull(localConn, buf[:2]); err != nil || buf[0] != 0x01 {
+		return false
+	}
+	user := make([]byte, int(buf[1]))
+	if _, err := io.ReadFull(localConn, user); err != nil {
+		return false
+	}
+	if _, err := io.ReadFull(localConn, buf[:1]); err != nil {
+		return false
+	}
+	pass := make([]byte, int(buf[0]))
+	if _, err := io.ReadFull(localConn, pass); err != nil {
+		return false
+	}
 
-	go func() {
-		_, err := io.Copy(localConn, remoteConn)
-		errChan <- err
-	}()
+	userOK := subtle.ConstantTimeCompare(user, []byte(h.Username)) == 1
+	passOK := subtle.ConstantTimeCompare(pass, []byte(h.Password)) == 1
+	if !userOK || !passOK {
+		log.Printf("[Proxy] 认证失败: %s (用户名: %s)", localConn.RemoteAddr(), user)
+		localConn.Write([]byte{0x01, 0x01})
+		return false
+	}
+	_, err := localConn.Write([]byte{0x01, 0x00})
+	return err == nil
+}
 
-	<-errChan
+// socksReply 构造 SOCKS5 应答: [VER][REP][RSV][ATYP][BND.ADDR][BND.PORT]
+// bind 为 nil 时使用 0.0.0.0:0
+func socksReply(rep byte, bind *net.UDPAddr) []byte {
+	host, port := "0.0.0.0", 0
+	if bind != nil {
+		host, port = bind.IP.String(), bind.Port
+	}
+	addr, err := protocol.ToSocksAddr(host, port)
+	if err != nil {
+		addr = []byte{0x01, 0, 0, 0, 0, 0, 0}
+	}
+	return append([]byte{0x05, rep, 0x00}, addr...)
 }
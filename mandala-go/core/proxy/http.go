@@ -0,0 +1,305 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"mandala/core/protocol"
+	"mandala/core/route"
+)
+
+// bufferedConn 允许在不丢失数据的前提下预读连接开头的字节 (用于入站协议识别)
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func newBufferedConn(conn net.Conn) *bufferedConn {
+	return &bufferedConn{Conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *bufferedConn) Peek(n int) ([]byte, error) {
+	return c.r.Peek(n)
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// HTTP 逐跳头部，转发前需要移除 (RFC 7230 6.1)
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// HandleHTTP 处理 HTTP 代理请求: CONNECT 隧道与绝对 URI 形式的普通 HTTP 请求
+func (h *Handler) HandleHTTP(localConn *bufferedConn) {
+	defer localConn.Close()
+
+	var (
+		remoteConn net.Conn
+		remoteBuf  *bufio.Reader
+		remoteDest protocol.Destination
+	)
+	defer func() {
+		if remoteConn != nil {
+			remoteConn.Close()
+		}
+	}()
+
+	// dial 关闭旧的出站连接并重新拨号，失败时已向客户端返回错误
+	dial := func(dest protocol.Destination) bool {
+		if remoteConn != nil {
+			remoteConn.Close()
+			remoteConn = nil
+		}
+		outbound, err := h.pick(route.InboundHTTP, "tcp", dest)
+		if err != nil {
+			writeRouteError(localConn, err)
+			return false
+		}
+		conn, err := outbound.DialTCP(context.Background(), dest)
+		if err != nil {
+			log.Printf("[HTTP] Dial %s failed: %v", dest, err)
+			writeHTTPError(localConn, http.StatusBadGateway)
+			return false
+		}
+		remoteConn = conn
+		remoteBuf = bufio.NewReader(conn)
+		remoteDest = dest
+		return true
+	}
+
+	for {
+		req, err := http.ReadRequest(localConn.r)
+		if err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				writeHTTPError(localConn, http.StatusBadRequest)
+			}
+			return
+		}
+
+		if !h.checkHTTPAuth(req) {
+			writeHTTPError(localConn, http.StatusProxyAuthRequired, `Proxy-Authenticate: Basic realm="mandala"`)
+			return
+		}
+
+		if req.Method == http.MethodConnect {
+			h.handleHTTPConnect(localConn, req)
+			return
+		}
+
+		if !req.URL.IsAbs() || req.URL.Host == "" {
+			writeHTTPError(localConn, http.StatusBadRequest)
+			return
+		}
+		dest, err := httpDestination(req.URL.Host, req.URL.Scheme)
+		if err != nil {
+			writeHTTPError(localConn, http.StatusBadRequest)
+			return
+		}
+
+		// 目标变化时重新建立出站连接，同一目标复用
+		reused := remoteConn != nil && dest == remoteDest
+		if !reused && !dial(dest) {
+			return
+		}
+
+		// 转换为 origin-form 并移除逐跳头部
+		upgrade := req.Header.Get("Upgrade")
+		removeHopHeaders(req.Header)
+		if upgrade != "" {
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", upgrade)
+		}
+		req.RequestURI = ""
+
+		resp, err := roundTripHTTP(remoteConn, remoteBuf, req)
+		if err != nil && reused && isRetryableHTTP(req) {
+			// 复用的空闲连接可能已被源站关闭，幂等且无正文的请求重新拨号后重试一次
+			log.Printf("[HTTP] Reused connection to %s failed: %v, retrying", dest, err)
+			if !dial(dest) {
+				return
+			}
+			resp, err = roundTripHTTP(remoteConn, remoteBuf, req)
+		}
+		if err != nil {
+			writeHTTPError(localConn, http.StatusBadGateway)
+			return
+		}
+
+		// 协议升级 (如 WebSocket): 回写响应后转为原始双向转发
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			if err := resp.Write(localConn); err != nil {
+				return
+			}
+			relay(localConn, &bufferedConn{Conn: remoteConn, r: remoteBuf})
+			return
+		}
+
+		err = resp.Write(localConn)
+		resp.Body.Close()
+		if err != nil || req.Close || resp.Close {
+			return
+		}
+	}
+}
+
+// roundTripHTTP 在出站连接上发送请求并读取响应头
+func roundTripHTTP(conn net.Conn, r *bufio.Reader, req *http.Request) (*http.Response, error) {
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	return http.ReadResponse(r, req)
+}
+
+// isRetryableHTTP 判断请求在出站连接失效后能否原样重发: 方法幂等且没有请求正文
+func isRetryableHTTP(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return req.Body == nil || req.Body == http.NoBody
+	}
+	return false
+}
+
+// handleHTTPConnect 处理 CONNECT host:port 隧道
+func (h *Handler) handleHTTPConnect(localConn *bufferedConn, req *http.Request) {
+	dest, err := httpDestination(req.Host, "https")
+	if err != nil {
+		writeHTTPError(localConn, http.StatusBadRequest)
+		return
+	}
+
+	outbound, err := h.pick(route.InboundHTTP, "tcp", dest)
+	if err != nil {
+		writeRouteError(localConn, err)
+		return
+	}
+
+	remoteConn, err := outbound.DialTCP(context.Background(), dest)
+	if err != nil {
+		log.Printf("[HTTP] Dial %s failed: %v", dest, err)
+		writeHTTPError(localConn, http.StatusBadGateway)
+		return
+	}
+	defer remoteConn.Close()
+
+	if _, err := io.WriteString(localConn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return
+	}
+	relay(localConn, remoteConn)
+}
+
+// checkHTTPAuth 校验 Proxy-Authorization (Basic)，未配置用户名时直接放行
+func (h *Handler) checkHTTPAuth(req *http.Request) bool {
+	if h.Username == "" {
+		return true
+	}
+	auth := req.Header.Get("Proxy-Authorization")
+	const prefix = "Basic "
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return false
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(auth[len(prefix):]))
+	if err != nil {
+		return false
+	}
+	user, pass, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(h.Username)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(h.Password)) == 1
+	return userOK && passOK
+}
+
+// httpDestination 解析 host[:port]，缺省端口按 scheme 推断
+func httpDestination(hostport, scheme string) (protocol.Destination, error) {
+	if _, _, err := net.SplitHostPort(hostport); err != nil {
+		port := "80"
+		if scheme == "https" {
+			port = "443"
+		}
+		hostport = net.JoinHostPort(strings.Trim(hostport, "[]"), port)
+	}
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return protocol.Destination{}, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return protocol.Destination{}, &net.AddrError{Err: "invalid port", Addr: hostport}
+	}
+	return protocol.Destination{Host: host, Port: port}, nil
+}
+
+func removeHopHeaders(header http.Header) {
+	// Connection 中列出的头部同样是逐跳的
+	for _, v := range header["Connection"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+}
+
+// writeHTTPError 向客户端返回一个不带正文的错误响应
+func writeHTTPError(conn net.Conn, code int, extraHeaders ...string) {
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 " + strconv.Itoa(code) + " " + http.StatusText(code) + "\r\n")
+	for _, h := range extraHeaders {
+		b.WriteString(h + "\r\n")
+	}
+	b.WriteString("Content-Length: 0\r\nConnection: close\r\n\r\n")
+	io.WriteString(conn, b.String())
+}
+
+// writeRouteError 路由失败时的响应: 被规则拦截返回 403，其余返回 502
+func writeRouteError(conn net.Conn, err error) {
+	if err == route.ErrBlocked {
+		writeHTTPError(conn, http.StatusForbidden)
+		return
+	}
+	log.Printf("[HTTP] %v", err)
+	writeHTTPError(conn, http.StatusBadGateway)
+}
+
+// relay 在两条连接之间双向转发，任一方向结束即返回
+func relay(localConn, remoteConn net.Conn) {
+	localConn.SetDeadline(time.Time{})
+	remoteConn.SetDeadline(time.Time{})
+
+	errChan := make(chan error, 2)
+
+	go func() {
+		_, err := io.Copy(remoteConn, localConn)
+		errChan <- err
+	}()
+
+	go func() {
+		_, err := io.Copy(localConn, remoteConn)
+		errChan <- err
+	}()
+
+	<-errChan
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"mandala/core/protocol"
+)
+
+// countingOutbound 直连 addr 并统计 TCP 拨号次数
+type countingOutbound struct {
+	addr  string
+	dials int32
+}
+
+func (o *countingOutbound) DialTCP(ctx context.Context, dest protocol.Destination) (net.Conn, error) {
+	atomic.AddInt32(&o.dials, 1)
+	return net.Dial("tcp", o.addr)
+}
+
+func (o *countingOutbound) DialUDP(ctx context.Context, dest protocol.Destination) (net.Conn, error) {
+	return nil, net.ErrClosed
+}
+
+// startOneShotOrigin 启动一个源站: 每条连接只应答一个请求 (不声明 Connection: close) 随后关闭，
+// 关闭后向返回的通道发送信号，模拟源站回收空闲的 keep-alive 连接
+func startOneShotOrigin(t *testing.T) (string, <-chan struct{}) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	closed := make(chan struct{}, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() {
+					conn.Close()
+					closed <- struct{}{}
+				}()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				io.Copy(io.Discard, req.Body)
+				io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+			}()
+		}
+	}()
+	return ln.Addr().String(), closed
+}
+
+func TestHTTPRetriesStaleKeepAlive(t *testing.T) {
+	addr, closed := startOneShotOrigin(t)
+	out := &countingOutbound{addr: addr}
+	h := &Handler{Outbound: out}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go h.HandleHTTP(newBufferedConn(server))
+	br := bufio.NewReader(client)
+
+	do := func(raw string) *http.Response {
+		t.Helper()
+		if _, err := io.WriteString(client, raw); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := do("GET http://origin.test/a HTTP/1.1\r\nHost: origin.test\r\n\r\n"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("first GET: status %d", resp.StatusCode)
+	}
+	<-closed
+
+	// 复用的连接已被源站关闭，GET 应重新拨号并成功
+	if resp := do("GET http://origin.test/b HTTP/1.1\r\nHost: origin.test\r\n\r\n"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET on stale connection: status %d", resp.StatusCode)
+	}
+	if n := atomic.LoadInt32(&out.dials); n != 2 {
+		t.Fatalf("dials = %d, want 2", n)
+	}
+	<-closed
+
+	// 带正文的 POST 不可重发，直接返回 502
+	resp := do("POST http://origin.test/c HTTP/1.1\r\nHost: origin.test\r\nContent-Length: 7\r\n\r\npayload")
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("POST on stale connection: status %d, want 502", resp.StatusCode)
+	}
+	if n := atomic.LoadInt32(&out.dials); n != 2 {
+		t.Fatalf("dials = %d, want 2", n)
+	}
+}
+
+func TestIsRetryableHTTP(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want bool
+	}{
+		{"GET / HTTP/1.1\r\nHost: a\r\n\r\n", true},
+		{"HEAD / HTTP/1.1\r\nHost: a\r\n\r\n", true},
+		{"DELETE / HTTP/1.1\r\nHost: a\r\n\r\n", true},
+		{"PUT / HTTP/1.1\r\nHost: a\r\nContent-Length: 0\r\n\r\n", true},
+		{"PUT / HTTP/1.1\r\nHost: a\r\nContent-Length: 1\r\n\r\nx", false},
+		{"POST / HTTP/1.1\r\nHost: a\r\n\r\n", false},
+		{"PATCH / HTTP/1.1\r\nHost: a\r\n\r\n", false},
+	}
+	for _, tt := range tests {
+		req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(tt.raw)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := isRetryableHTTP(req); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.raw, got, tt.want)
+		}
+	}
+}
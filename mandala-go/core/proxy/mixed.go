@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"time"
+)
+
+// sniffTimeout 等待客户端发送首字节的最长时间
+const sniffTimeout = 10 * time.Second
+
+// Serve 在混合端口上预读首字节识别入站协议并分发:
+//   - 0x04: SOCKS4 / SOCKS4a
+//   - 0x05: SOCKS5
+//   - ASCII 大写字母: HTTP 代理 (CONNECT / GET / POST ...)
+//
+// 识别失败时直接关闭连接，无法以任何一种协议格式回复
+func (h *Handler) Serve(conn net.Conn) {
+	bc := newBufferedConn(conn)
+
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	first, err := bc.Peek(1)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	switch b := first[0]; {
+	case b == 0x04:
+		h.HandleSocks4(bc)
+	case b == 0x05:
+		h.HandleConnection(bc)
+	case b >= 'A' && b <= 'Z':
+		h.HandleHTTP(bc)
+	default:
+		log.Printf("[Proxy] 未知入站协议 (首字节 0x%02x): %s", b, conn.RemoteAddr())
+		conn.Close()
+	}
+}
@@ -0,0 +1,226 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"mandala/core/config"
+	"mandala/core/group"
+	"mandala/core/protocol"
+)
+
+// OutboundSet 按标签管理全部出站 (包括 selector 等分组)
+type OutboundSet struct {
+	def    protocol.Outbound
+	byTag  map[string]protocol.Outbound
+	groups []group.Group
+}
+
+// NewOutboundSet 根据总配置创建全部出站
+// CurrentNode 与 Outbounds 中的第一项 (按此顺序) 作为默认代理出站
+func NewOutboundSet(cfg *config.Config) (*OutboundSet, error) {
+	var nodes []*config.OutboundConfig
+	if cfg.CurrentNode != nil {
+		nodes = append(nodes, cfg.CurrentNode)
+	}
+	for i := range cfg.Outbounds {
+		nodes = append(nodes, &cfg.Outbounds[i])
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no outbound node configured")
+	}
+
+	b := &outboundBuilder{
+		configs: make(map[string]*config.OutboundConfig),
+		set:     &OutboundSet{byTag: make(map[string]protocol.Outbound)},
+	}
+	for _, n := range nodes {
+		if n.Tag == "" {
+			continue
+		}
+		if _, ok := b.configs[n.Tag]; ok {
+			return nil, fmt.Errorf("duplicate outbound tag: %s", n.Tag)
+		}
+		b.configs[n.Tag] = n
+	}
+
+	for i, n := range nodes {
+		var (
+			ob  protocol.Outbound
+			err error
+		)
+		if n.Tag != "" {
+			ob, err = b.resolve(n.Tag)
+		} else {
+			ob, err = b.build(n)
+		}
+		if err != nil {
+			b.set.Close()
+			return nil, err
+		}
+		if i == 0 {
+			b.set.def = ob
+		}
+	}
+
+	log.Printf("[Outbound] 已创建 %d 个出站, %d 个分组", len(nodes), len(b.set.groups))
+	return b.set, nil
+}
+
+// Default 返回默认代理出站
+func (s *OutboundSet) Default() protocol.Outbound {
+	return s.def
+}
+
+// Tagged 返回按标签索引的全部出站，供路由规则引用
+func (s *OutboundSet) Tagged() map[string]protocol.Outbound {
+	return s.byTag
+}
+
+// Groups 返回全部分组
+func (s *OutboundSet) Groups() []group.Group {
+	return s.groups
+}
+
+// Select 在包含 tag 的 selector 中切换到该成员
+// 默认出站为 selector 时优先使用它，否则使用第一个包含 tag 的 selector
+func (s *OutboundSet) Select(tag string) error {
+	if sel, ok := s.def.(*group.Selector); ok && sel.Has(tag) {
+		return sel.Select(tag)
+	}
+	for _, g := range s.groups {
+		if sel, ok := g.(*group.Selector); ok && sel.Has(tag) {
+			return sel.Select(tag)
+		}
+	}
+	return fmt.Errorf("no selector contains outbound: %s", tag)
+}
+
+// Latencies 汇总所有测速分组的结果: 出站标签 -> 延迟毫秒 (失败为 -1)
+func (s *OutboundSet) Latencies() map[string]int {
+	out := make(map[string]int)
+	for _, g := range s.groups {
+		if r, ok := g.(group.LatencyReporter); ok {
+			for tag, d := range r.Latencies() {
+				out[tag] = d
+			}
+		}
+	}
+	return out
+}
+
+// Close 停止分组的后台任务 (测速等)
+func (s *OutboundSet) Close() {
+	for _, g := range s.groups {
+		if c, ok := g.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+// outboundBuilder 按依赖顺序创建出站，分组成员与 detour 先于引用它们的出站创建
+type outboundBuilder struct {
+	configs map[string]*config.OutboundConfig
+	set     *OutboundSet
+	stack   []string // 正在创建中的标签，用于检测循环引用
+}
+
+// resolve 按标签获取出站，未创建时先创建
+func (b *outboundBuilder) resolve(tag string) (protocol.Outbound, error) {
+	if ob, ok := b.set.byTag[tag]; ok {
+		return ob, nil
+	}
+	for i, t := range b.stack {
+		if t == tag {
+			return nil, fmt.Errorf("outbound loop detected: %s", strings.Join(append(b.stack[i:], tag), " -> "))
+		}
+	}
+	cfg, ok := b.configs[tag]
+	if !ok {
+		return nil, fmt.Errorf("unknown outbound tag: %s", tag)
+	}
+
+	b.stack = append(b.stack, tag)
+	ob, err := b.build(cfg)
+	b.stack = b.stack[:len(b.stack)-1]
+	if err != nil {
+		return nil, err
+	}
+	b.set.byTag[tag] = ob
+	return ob, nil
+}
+
+func (b *outboundBuilder) build(cfg *config.OutboundConfig) (protocol.Outbound, error) {
+	switch strings.ToLower(cfg.Type) {
+	case "selector":
+		members, err := b.members(cfg)
+		if err != nil {
+			return nil, err
+		}
+		sel, err := group.NewSelector(cfg.Tag, members, cfg.Default)
+		if err != nil {
+			return nil, err
+		}
+		b.set.groups = append(b.set.groups, sel)
+		return sel, nil
+	case "urltest":
+		members, err := b.members(cfg)
+		if err != nil {
+			return nil, err
+		}
+		g, err := group.NewURLTest(cfg.Tag, members, cfg.URL,
+			time.Duration(cfg.Interval)*time.Second, time.Duration(cfg.Tolerance)*time.Millisecond)
+		if err != nil {
+			return nil, err
+		}
+		b.set.groups = append(b.set.groups, g)
+		return g, nil
+	case "fallback":
+		members, err := b.members(cfg)
+		if err != nil {
+			return nil, err
+		}
+		g, err := group.NewFallback(cfg.Tag, members, cfg.URL, time.Duration(cfg.Interval)*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		b.set.groups = append(b.set.groups, g)
+		return g, nil
+	case "loadbalance":
+		members, err := b.members(cfg)
+		if err != nil {
+			return nil, err
+		}
+		g, err := group.NewLoadBalance(cfg.Tag, members, cfg.Strategy, cfg.URL, time.Duration(cfg.Interval)*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		b.set.groups = append(b.set.groups, g)
+		return g, nil
+	}
+
+	if cfg.Detour != "" {
+		detour, err := b.resolve(cfg.Detour)
+		if err != nil {
+			return nil, fmt.Errorf("detour of %s: %v", cfg.Tag, err)
+		}
+		return NewOutboundWithDetour(cfg, detour)
+	}
+	return NewOutbound(cfg)
+}
+
+// members 创建分组的全部成员
+func (b *outboundBuilder) members(cfg *config.OutboundConfig) ([]group.Member, error) {
+	members := make([]group.Member, 0, len(cfg.Outbounds))
+	for _, tag := range cfg.Outbounds {
+		ob, err := b.resolve(tag)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %v", cfg.Type, cfg.Tag, err)
+		}
+		members = append(members, group.Member{Tag: tag, Outbound: ob})
+	}
+	return members, nil
+}
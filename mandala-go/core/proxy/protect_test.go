@@ -0,0 +1,205 @@
+//go:build linux
+
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"mandala/core/config"
+	"mandala/core/internal/protecttest"
+	"mandala/core/protocol"
+
+	"github.com/miekg/dns"
+)
+
+// peerCheck 收集各测试服务端看到的客户端套接字是否受保护
+type peerCheck struct {
+	mu      sync.Mutex
+	checked map[string]int
+	failed  []string
+}
+
+func newPeerCheck() *peerCheck {
+	return &peerCheck{checked: make(map[string]int)}
+}
+
+func (c *peerCheck) check(r *protecttest.Recorder, name string, peer net.Addr) {
+	ok := r.Protected(peer)
+	c.mu.Lock()
+	c.checked[name]++
+	if !ok {
+		c.failed = append(c.failed, name+" "+peer.String())
+	}
+	c.mu.Unlock()
+}
+
+func (c *peerCheck) verify(t *testing.T, names ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range c.failed {
+		t.Errorf("unprotected socket: %s", f)
+	}
+	for _, name := range names {
+		if c.checked[name] == 0 {
+			t.Errorf("no %s socket seen", name)
+		}
+	}
+}
+
+// startTestDNS 启动测试 DNS 服务器，测试用域名均解析为 127.0.0.1，并检查每个查询套接字是否受保护
+func startTestDNS(t *testing.T, r *protecttest.Recorder, pc *peerCheck) {
+	records := map[string]string{"server.test.": "127.0.0.1", "doh.test.": "127.0.0.1"}
+	protecttest.StartDNS(t, protocol.SetResolverServers, records, func(peer net.Addr) { pc.check(r, "dns", peer) })
+}
+
+// startTestSocks5 启动支持 CONNECT 与 UDP ASSOCIATE 的 SOCKS5 服务端，CONNECT 与 UDP 均回显数据
+// UDP ASSOCIATE 应答的中继地址为 0.0.0.0，客户端须回退到服务器域名
+func startTestSocks5(t *testing.T, r *protecttest.Recorder, pc *peerCheck) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ln.Close()
+		relay.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := relay.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.check(r, "relay", addr)
+			relay.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				pc.check(r, "server", c.RemoteAddr())
+				head := make([]byte, 3)
+				if _, err := io.ReadFull(c, head); err != nil {
+					return
+				}
+				c.Write([]byte{0x05, 0x00})
+				if _, err := io.ReadFull(c, head); err != nil {
+					return
+				}
+				if _, _, err := protocol.ReadSocksAddr(c); err != nil {
+					return
+				}
+				bound, _ := protocol.ToSocksAddr("0.0.0.0", relay.LocalAddr().(*net.UDPAddr).Port)
+				c.Write(append([]byte{0x05, 0x00, 0x00}, bound...))
+				io.Copy(c, c)
+			}(c)
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestProtectServerAndSocks5Relay(t *testing.T) {
+	rec := protecttest.Install(t, protocol.SetSocketProtector)
+	pc := newPeerCheck()
+	startTestDNS(t, rec, pc)
+	port := startTestSocks5(t, rec, pc)
+
+	// 服务器地址为域名，连接与解析均须受保护
+	out, err := NewOutbound(&config.OutboundConfig{Type: "socks5", Server: "server.test", ServerPort: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dest := protocol.Destination{Host: "example.com", Port: 53}
+
+	tcpConn, err := out.DialTCP(ctx, dest)
+	if err != nil {
+		t.Fatalf("dial tcp: %v", err)
+	}
+	defer tcpConn.Close()
+	echo(t, tcpConn)
+
+	udpConn, err := out.DialUDP(ctx, dest)
+	if err != nil {
+		t.Fatalf("dial udp: %v", err)
+	}
+	defer udpConn.Close()
+	echo(t, udpConn)
+
+	pc.verify(t, "dns", "server", "relay")
+}
+
+func TestProtectECHDoH(t *testing.T) {
+	rec := protecttest.Install(t, protocol.SetSocketProtector)
+	pc := newPeerCheck()
+	startTestDNS(t, rec, pc)
+
+	doh := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		peer, _ := net.ResolveTCPAddr("tcp", req.RemoteAddr)
+		pc.check(rec, "doh", peer)
+		query := new(dns.Msg)
+		data, _ := base64.RawURLEncoding.DecodeString(req.URL.Query().Get("dns"))
+		if err := query.Unpack(data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp := new(dns.Msg)
+		resp.SetReply(query)
+		resp.Answer = append(resp.Answer, &dns.HTTPS{SVCB: dns.SVCB{
+			Hdr:      dns.RR_Header{Name: query.Question[0].Name, Rrtype: dns.TypeHTTPS, Class: dns.ClassINET, Ttl: 60},
+			Priority: 1,
+			Target:   ".",
+			Value:    []dns.SVCBKeyValue{&dns.SVCBECHConfig{ECH: []byte{0xfe, 0x0d}}},
+		}})
+		out, _ := resp.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(out)
+	}))
+	defer doh.Close()
+
+	// DoH 地址使用域名，解析与 HTTPS 连接均须受保护
+	_, port, _ := net.SplitHostPort(doh.Listener.Addr().String())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ech, err := resolveECHConfig(ctx, "https://doh.test:"+port+"/dns-query", "ech.example.com")
+	if err != nil {
+		t.Fatalf("resolve ech: %v", err)
+	}
+	if len(ech) != 2 {
+		t.Fatalf("ech config = %x", ech)
+	}
+
+	pc.verify(t, "dns", "doh")
+}
+
+func echo(t *testing.T, c net.Conn) {
+	t.Helper()
+	c.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("read: %q %v", buf, err)
+	}
+}
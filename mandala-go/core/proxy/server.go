@@ -2,44 +2,116 @@ package proxy
 
 import (
 	"fmt"
+	"log"
 	"net"
+	"strconv"
 	"sync"
 
 	"mandala/core/config"
+	"mandala/core/dns"
+	"mandala/core/protocol"
+	"mandala/core/route"
 )
 
 // Server 本地代理服务器
 type Server struct {
-	listener net.Listener
-	config   *config.OutboundConfig
-	running  bool
-	mu       sync.Mutex
+	listener  net.Listener
+	config    *config.OutboundConfig
+	outbound  protocol.Outbound
+	outbounds *OutboundSet
+	router    *route.Router
+	resolver  *dns.Resolver // ECH 密钥查询使用的解析器，未启用 ECH 时为 nil
+	acl       *accessList
+	username  string
+	password  string
+	running   bool
+	mu        sync.Mutex
 }
 
 var GlobalServer *Server
 
-// Start 启动本地 SOCKS5 服务器
+// Start 启动本地混合端口代理服务器 (SOCKS4/4a、SOCKS5 与 HTTP 代理)
 // localPort: Android 本地监听端口 (如 10809)
-// jsonConfig: 节点配置 JSON
+// jsonConfig: 节点配置 JSON (可同时包含 listen_addr / auth_* / *_cidrs 等入站设置)
 func Start(localPort int, jsonConfig string) error {
+	cfg, err := config.ParseServerConfig(jsonConfig)
+	if err != nil {
+		return err
+	}
+	if localPort > 0 {
+		cfg.LocalPort = localPort
+	}
+	return StartWithConfig(cfg)
+}
+
+// StartWithConfig 按总配置启动本地代理服务器
+func StartWithConfig(cfg *config.Config) error {
 	Stop() // 停止旧实例
 
-	cfg, err := config.ParseConfig(jsonConfig)
+	protocol.SetSocketBinding(cfg.RoutingMark, cfg.BindInterface)
+	if len(cfg.BootstrapDNS) > 0 {
+		protocol.SetResolverServers(cfg.BootstrapDNS)
+	}
+
+	outbounds, err := NewOutboundSet(cfg)
+	if err != nil {
+		return err
+	}
+
+	router, err := route.NewRouter(cfg.Route, outbounds.Default(), outbounds.Tagged())
 	if err != nil {
+		outbounds.Close()
 		return err
 	}
 
-	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", localPort))
+	acl, err := newAccessList(cfg.AllowCIDRs, cfg.DenyCIDRs)
+	if err != nil {
+		outbounds.Close()
+		return err
+	}
+
+	// ECH 密钥查询与 TUN 模式使用同一套 DNS 上游配置
+	var resolver *dns.Resolver
+	if usesECH(cfg) {
+		if resolver, err = dns.NewResolver(cfg.DNS, router.Outbound); err != nil {
+			outbounds.Close()
+			return err
+		}
+	}
+
+	listenAddr := cfg.ListenAddr
+	if listenAddr == "" {
+		listenAddr = "127.0.0.1"
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(listenAddr, strconv.Itoa(cfg.LocalPort)))
 	if err != nil {
+		if resolver != nil {
+			resolver.Close()
+		}
+		outbounds.Close()
 		return err
 	}
 
+	if ip := net.ParseIP(listenAddr); (ip == nil || !ip.IsLoopback()) && cfg.AuthUsername == "" && len(cfg.AllowCIDRs) == 0 {
+		log.Printf("[Proxy] 警告: 监听 %s 且未配置认证或来源限制，局域网内任意设备均可使用", l.Addr())
+	}
+
 	srv := &Server{
-		listener: l,
-		config:   cfg,
-		running:  true,
+		listener:  l,
+		config:    cfg.CurrentNode,
+		outbound:  outbounds.Default(),
+		outbounds: outbounds,
+		router:    router,
+		resolver:  resolver,
+		acl:       acl,
+		username:  cfg.AuthUsername,
+		password:  cfg.AuthPassword,
+		running:   true,
 	}
 	GlobalServer = srv
+	if resolver != nil {
+		SetECHResolver(resolver)
+	}
 
 	go srv.serve()
 	return nil
@@ -55,23 +127,55 @@ func Stop() {
 			if GlobalServer.listener != nil {
 				GlobalServer.listener.Close()
 			}
+			if GlobalServer.outbounds != nil {
+				GlobalServer.outbounds.Close()
+			}
+			if GlobalServer.resolver != nil {
+				clearECHResolver(GlobalServer.resolver)
+				GlobalServer.resolver.Close()
+			}
 		}
 		GlobalServer = nil
 	}
 }
 
+// usesECH 判断是否有节点启用了 ECH
+func usesECH(cfg *config.Config) bool {
+	if cfg.CurrentNode != nil && cfg.CurrentNode.TLS != nil && cfg.CurrentNode.TLS.EnableECH {
+		return true
+	}
+	for i := range cfg.Outbounds {
+		if tls := cfg.Outbounds[i].TLS; tls != nil && tls.EnableECH {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Server) serve() {
-	for s.running {
+	for s.isRunning() {
 		conn, err := s.listener.Accept()
 		if err != nil {
-			if s.running {
+			if s.isRunning() {
 				fmt.Printf("Accept error: %v\n", err)
 			}
 			return
 		}
 		
-		handler := &Handler{Config: s.config}
-		go handler.HandleConnection(conn)
+		if !s.acl.Allowed(conn.RemoteAddr()) {
+			log.Printf("[Proxy] 拒绝来源: %s", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+
+		handler := &Handler{
+			Config:   s.config,
+			Outbound: s.outbound,
+			Router:   s.router,
+			Username: s.username,
+			Password: s.password,
+		}
+		go handler.Serve(conn)
 	}
 }
 // core/proxy/server.go 追加内容:
@@ -79,7 +183,11 @@ func IsRunning() bool {
 	if GlobalServer == nil {
 		return false
 	}
-	GlobalServer.mu.Lock()
-	defer GlobalServer.mu.Unlock()
-	return GlobalServer.running
+	return GlobalServer.isRunning()
+}
+
+func (s *Server) isRunning() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running
 }
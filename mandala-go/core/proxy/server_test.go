@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"testing"
+
+	"mandala/core/config"
+)
+
+func TestStartInstallsECHResolver(t *testing.T) {
+	node := &config.OutboundConfig{
+		Type:       "socks5",
+		Server:     "127.0.0.1",
+		ServerPort: 1080,
+		TLS:        &config.TLSConfig{Enabled: true, EnableECH: true},
+	}
+	if err := StartWithConfig(&config.Config{CurrentNode: node}); err != nil {
+		t.Fatal(err)
+	}
+	defer Stop()
+
+	echResolverMutex.RLock()
+	installed := echResolver
+	echResolverMutex.RUnlock()
+	if installed == nil || installed != ECHResolver(GlobalServer.resolver) {
+		t.Fatalf("ech resolver = %v, want server resolver", installed)
+	}
+
+	Stop()
+	echResolverMutex.RLock()
+	installed = echResolver
+	echResolverMutex.RUnlock()
+	if installed != nil {
+		t.Fatalf("ech resolver not cleared on stop: %v", installed)
+	}
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"strconv"
+
+	"mandala/core/protocol"
+	"mandala/core/route"
+)
+
+// SOCKS4 指令与应答码
+const (
+	socks4CmdConnect = 0x01
+
+	socks4RepGranted  = 0x5A
+	socks4RepRejected = 0x5B
+)
+
+// socks4MaxField 限制 USERID / 域名等以 NUL 结尾字段的长度
+const socks4MaxField = 255
+
+// HandleSocks4 处理 SOCKS4 / SOCKS4a 请求 (仅支持 CONNECT)
+// 请求格式: [VN(0x04)][CD][DSTPORT(2)][DSTIP(4)][USERID][NUL]
+// SOCKS4a: DSTIP 为 0.0.0.x (x != 0) 时，USERID 之后追加 [DOMAIN][NUL]
+func (h *Handler) HandleSocks4(localConn *bufferedConn) {
+	defer localConn.Close()
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(localConn, header); err != nil {
+		return
+	}
+	if header[0] != 0x04 {
+		return
+	}
+	cmd := header[1]
+	port := int(binary.BigEndian.Uint16(header[2:4]))
+	ip := net.IP(header[4:8])
+
+	userID, err := readNulString(localConn)
+	if err != nil {
+		return
+	}
+
+	host := ip.String()
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		// SOCKS4a: 由代理解析域名
+		if host, err = readNulString(localConn); err != nil || host == "" {
+			localConn.Write(socks4Reply(socks4RepRejected))
+			return
+		}
+	}
+
+	// SOCKS4 无法携带密码，启用认证时一律拒绝
+	if h.Username != "" {
+		log.Printf("[Proxy] 拒绝 SOCKS4 请求 (已启用认证): %s (USERID: %s)", localConn.RemoteAddr(), userID)
+		localConn.Write(socks4Reply(socks4RepRejected))
+		return
+	}
+
+	if cmd != socks4CmdConnect {
+		localConn.Write(socks4Reply(socks4RepRejected))
+		return
+	}
+
+	dest := protocol.Destination{Host: host, Port: port}
+	outbound, err := h.pick(route.InboundSocks4, "tcp", dest)
+	if err != nil {
+		if err != route.ErrBlocked {
+			log.Printf("[Proxy] %v", err)
+		}
+		localConn.Write(socks4Reply(socks4RepRejected))
+		return
+	}
+
+	remoteConn, err := outbound.DialTCP(context.Background(), dest)
+	if err != nil {
+		log.Printf("[Proxy] Dial %s failed: %v", dest, err)
+		localConn.Write(socks4Reply(socks4RepRejected))
+		return
+	}
+	defer remoteConn.Close()
+
+	if _, err := localConn.Write(socks4Reply(socks4RepGranted)); err != nil {
+		return
+	}
+	relay(localConn, remoteConn)
+}
+
+// socks4Reply 构造 SOCKS4 应答: [VN(0x00)][CD][DSTPORT(2)][DSTIP(4)]，CONNECT 下端口与地址可忽略
+func socks4Reply(rep byte) []byte {
+	return []byte{0x00, rep, 0, 0, 0, 0, 0, 0}
+}
+
+// readNulString 读取以 NUL 结尾的字段
+func readNulString(conn *bufferedConn) (string, error) {
+	buf := make([]byte, 0, 32)
+	for {
+		b, err := conn.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == 0 {
+			return string(buf), nil
+		}
+		if len(buf) >= socks4MaxField {
+			return "", &net.AddrError{Err: "socks4 field too long", Addr: strconv.Itoa(len(buf))}
+		}
+		buf = append(buf, b)
+	}
+}
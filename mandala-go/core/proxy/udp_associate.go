@@ -0,0 +1,255 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"mandala/core/protocol"
+	"mandala/core/route"
+)
+
+const (
+	socksUDPTimeout = 60 * time.Second
+	// socksUDPQueueSize 为会话建立期间每个目标最多排队的数据报数，超出部分丢弃
+	socksUDPQueueSize = 64
+)
+
+// udpAssociation 维护一次 UDP ASSOCIATE 的中继状态
+// 生命周期与控制用的 TCP 连接绑定：TCP 断开时关闭中继端口与全部远程会话
+type udpAssociation struct {
+	relay   *net.UDPConn
+	handler *Handler
+	ctx     context.Context
+
+	clientIP   net.IP
+	clientAddr *net.UDPAddr // 首个合法数据报的来源地址
+
+	mu       sync.Mutex
+	sessions map[string]*udpSession // 目标地址 -> 出站 UDP 会话
+}
+
+// udpSession 为到单个目标的出站 UDP 会话
+// 拨号在独立协程中进行，建立完成前到达的数据报暂存在 pending 中
+type udpSession struct {
+	conn    net.Conn // 建立完成前为 nil
+	pending [][]byte
+}
+
+// handleUDPAssociate 处理 SOCKS5 UDP ASSOCIATE (CMD 0x03)
+func (h *Handler) handleUDPAssociate(localConn net.Conn) {
+	// 中继端口绑定在与 TCP 监听相同的地址上
+	bindIP := net.IPv4(127, 0, 0, 1)
+	if tcpAddr, ok := localConn.LocalAddr().(*net.TCPAddr); ok {
+		bindIP = tcpAddr.IP
+	}
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: bindIP, Port: 0})
+	if err != nil {
+		log.Printf("[Proxy] UDP relay listen failed: %v", err)
+		localConn.Write(socksReply(socksRepGeneralFailure, nil))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assoc := &udpAssociation{
+		relay:    relay,
+		handler:  h,
+		ctx:      ctx,
+		sessions: make(map[string]*udpSession),
+	}
+	if tcpAddr, ok := localConn.RemoteAddr().(*net.TCPAddr); ok {
+		assoc.clientIP = tcpAddr.IP
+	}
+	defer func() {
+		cancel()
+		assoc.close()
+	}()
+
+	if _, err := localConn.Write(socksReply(socksRepSuccess, relay.LocalAddr().(*net.UDPAddr))); err != nil {
+		return
+	}
+	log.Printf("[Proxy] UDP ASSOCIATE 中继已建立: %s", relay.LocalAddr())
+
+	go assoc.serve()
+
+	// 控制连接上不会再有数据，阻塞直到客户端关闭
+	localConn.SetDeadline(time.Time{})
+	io.Copy(io.Discard, localConn)
+}
+
+// serve 读取客户端数据报并转发到对应的出站会话
+// 数据报格式 (RFC 1928): [RSV(2)][FRAG(1)][ATYP][DST.ADDR][DST.PORT][DATA]
+func (a *udpAssociation) serve() {
+	buf := make([]byte, 65535)
+	for {
+		n, src, err := a.relay.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		// 只接受来自控制连接同一主机的数据报
+		if a.clientIP != nil && !a.clientIP.IsUnspecified() && !src.IP.Equal(a.clientIP) {
+			continue
+		}
+		if n < 4 || buf[0] != 0 || buf[1] != 0 {
+			continue
+		}
+		// 不支持分片，按 RFC 1928 要求直接丢弃
+		if buf[2] != 0 {
+			continue
+		}
+
+		reader := bytes.NewReader(buf[3:n])
+		host, port, err := protocol.ReadSocksAddr(reader)
+		if err != nil {
+			continue
+		}
+		payload := buf[n-reader.Len() : n]
+
+		a.mu.Lock()
+		a.clientAddr = src
+		a.mu.Unlock()
+
+		a.send(protocol.Destination{Host: host, Port: port}, payload)
+	}
+}
+
+// send 将数据报发往 dest 的出站会话
+// 会话不存在时在独立协程中创建，避免慢速拨号阻塞其他目标的数据报
+func (a *udpAssociation) send(dest protocol.Destination, payload []byte) {
+	key := dest.String()
+
+	a.mu.Lock()
+	if a.sessions == nil {
+		a.mu.Unlock()
+		return
+	}
+	s, ok := a.sessions[key]
+	if ok && s.conn != nil {
+		a.mu.Unlock()
+		if _, err := s.conn.Write(payload); err != nil {
+			a.drop(key, s)
+		}
+		return
+	}
+	if !ok {
+		s = &udpSession{}
+		a.sessions[key] = s
+		go a.dial(key, dest, s)
+	}
+	// payload 引用读缓冲区，排队前须复制
+	if len(s.pending) < socksUDPQueueSize {
+		s.pending = append(s.pending, append([]byte(nil), payload...))
+	}
+	a.mu.Unlock()
+}
+
+// dial 建立到 dest 的出站会话并按顺序发出排队的数据报
+func (a *udpAssociation) dial(key string, dest protocol.Destination, s *udpSession) {
+	outbound, err := a.handler.pick(route.InboundSocks5, "udp", dest)
+	var conn net.Conn
+	if err == nil {
+		conn, err = outbound.DialUDP(a.ctx, dest)
+	}
+	if err != nil {
+		if err != route.ErrBlocked {
+			log.Printf("[Proxy] UDP 会话创建失败 %s: %v", key, err)
+		}
+		a.drop(key, s)
+		return
+	}
+
+	// 排空队列后才公开 conn，保证新数据报不会越过排队中的数据报
+	for {
+		a.mu.Lock()
+		if a.sessions == nil || a.sessions[key] != s {
+			// 关联已关闭
+			a.mu.Unlock()
+			conn.Close()
+			return
+		}
+		pending := s.pending
+		s.pending = nil
+		if len(pending) == 0 {
+			s.conn = conn
+			a.mu.Unlock()
+			break
+		}
+		a.mu.Unlock()
+
+		for _, packet := range pending {
+			if _, err := conn.Write(packet); err != nil {
+				conn.Close()
+				a.drop(key, s)
+				return
+			}
+		}
+	}
+
+	go a.copyRemoteToClient(key, dest, s)
+}
+
+// copyRemoteToClient 将出站会话返回的数据报加上 SOCKS5 UDP 头写回客户端
+func (a *udpAssociation) copyRemoteToClient(key string, dest protocol.Destination, s *udpSession) {
+	defer a.drop(key, s)
+
+	header, err := protocol.ToSocksAddr(dest.Host, dest.Port)
+	if err != nil {
+		return
+	}
+	header = append([]byte{0, 0, 0}, header...)
+
+	buf := make([]byte, 65535)
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(socksUDPTimeout))
+		n, err := s.conn.Read(buf)
+		if err != nil {
+			return
+		}
+
+		a.mu.Lock()
+		client := a.clientAddr
+		a.mu.Unlock()
+		if client == nil {
+			continue
+		}
+
+		packet := make([]byte, 0, len(header)+n)
+		packet = append(packet, header...)
+		packet = append(packet, buf[:n]...)
+		if _, err := a.relay.WriteToUDP(packet, client); err != nil {
+			return
+		}
+	}
+}
+
+// drop 关闭会话并将其移出关联，s.conn 为 nil 表示会话尚未建立
+func (a *udpAssociation) drop(key string, s *udpSession) {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	a.mu.Lock()
+	if a.sessions != nil && a.sessions[key] == s {
+		delete(a.sessions, key)
+	}
+	a.mu.Unlock()
+}
+
+func (a *udpAssociation) close() {
+	a.relay.Close()
+	a.mu.Lock()
+	sessions := a.sessions
+	a.sessions = nil
+	a.mu.Unlock()
+	// 仍在拨号的会话由 dial 发现关联已关闭后自行关闭
+	for _, s := range sessions {
+		if s.conn != nil {
+			s.conn.Close()
+		}
+	}
+	log.Printf("[Proxy] UDP ASSOCIATE 中继已关闭: %s", a.relay.LocalAddr())
+}
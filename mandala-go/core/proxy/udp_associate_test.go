@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"mandala/core/protocol"
+)
+
+// gatedOutbound 的 UDP 会话直连本地回显服务器，目标为 slow.test 时拨号阻塞到 gate 关闭
+type gatedOutbound struct {
+	echo string
+	gate chan struct{}
+}
+
+func (o *gatedOutbound) DialTCP(ctx context.Context, dest protocol.Destination) (net.Conn, error) {
+	return nil, net.ErrClosed
+}
+
+func (o *gatedOutbound) DialUDP(ctx context.Context, dest protocol.Destination) (net.Conn, error) {
+	if dest.Host == "slow.test" {
+		select {
+		case <-o.gate:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return net.Dial("udp", o.echo)
+}
+
+func startUDPEcho(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buf[:n], addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestUDPAssociateSlowDialDoesNotBlock(t *testing.T) {
+	out := &gatedOutbound{echo: startUDPEcho(t), gate: make(chan struct{})}
+	h := &Handler{Outbound: out}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go h.handleUDPAssociate(server)
+
+	reply := make([]byte, 3)
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := protocol.ReadSocksAddr(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("udp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	send := func(host, data string) {
+		header, _ := protocol.ToSocksAddr(host, 53)
+		packet := append(append([]byte{0, 0, 0}, header...), data...)
+		if _, err := conn.Write(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recv := func(want string) {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		buf := make([]byte, 65535)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("waiting for %q: %v", want, err)
+		}
+		if !bytes.HasSuffix(buf[:n], []byte(want)) {
+			t.Fatalf("got %q, want %q", buf[:n], want)
+		}
+	}
+
+	// slow.test 拨号阻塞期间，其他目标的数据报照常转发
+	send("slow.test", "slow-1")
+	send("slow.test", "slow-2")
+	send("fast.test", "fast")
+	recv("fast")
+
+	// 拨号完成后排队的数据报按顺序发出
+	close(out.gate)
+	recv("slow-1")
+	recv("slow-2")
+}
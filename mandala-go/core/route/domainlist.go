@@ -0,0 +1,119 @@
+package route
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// hosts 文件中不作为拦截条目的常见本机名称
+var hostsIgnored = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+}
+
+// LoadDomainList 从文件加载域名列表
+func LoadDomainList(path string) (*DomainSet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open domain list failed: %v", err)
+	}
+	defer f.Close()
+	return ParseDomainList(f)
+}
+
+// ParseDomainList 解析域名列表，逐行自动识别格式:
+//   - 纯文本: "example.com" 或 "*.example.com"，匹配自身与子域名
+//   - hosts 文件: "0.0.0.0 ads.example.com"，仅匹配完整域名
+//   - AdGuard / ABP: "||example.com^"，匹配自身与子域名；例外规则 (@@)、含通配符或路径的规则、
+//     带 $important 以外修饰符的规则被忽略
+//
+// 以 "#" 或 "!" 开头的行为注释
+func ParseDomainList(r io.Reader) (*DomainSet, error) {
+	b := NewDomainSetBuilder()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		parseDomainListLine(b, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read domain list failed: %v", err)
+	}
+	return b.Build(), nil
+}
+
+func parseDomainListLine(b *DomainSetBuilder, line string) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' || line[0] == '!' || line[0] == '[' {
+		return
+	}
+
+	// AdGuard / ABP
+	if strings.HasPrefix(line, "||") {
+		rule, modifiers, _ := strings.Cut(line[2:], "$")
+		if modifiers != "" && modifiers != "important" {
+			return
+		}
+		domain := strings.TrimSuffix(rule, "^")
+		if validListDomain(domain) {
+			b.AddSuffix(domain)
+		}
+		return
+	}
+	if strings.HasPrefix(line, "@@") || strings.HasPrefix(line, "|") {
+		return
+	}
+
+	// 去掉行尾注释
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return
+	}
+
+	// hosts 文件: IP 后跟一个或多个域名
+	if net.ParseIP(fields[0]) != nil {
+		for _, domain := range fields[1:] {
+			if !hostsIgnored[strings.ToLower(domain)] && validListDomain(domain) {
+				b.AddExact(domain)
+			}
+		}
+		return
+	}
+
+	if len(fields) == 1 {
+		domain := strings.TrimPrefix(fields[0], "*.")
+		if validListDomain(domain) {
+			b.AddSuffix(domain)
+		}
+	}
+}
+
+// validListDomain 过滤含通配符、路径或非法字符的条目
+func validListDomain(domain string) bool {
+	domain = strings.TrimPrefix(domain, ".")
+	if domain == "" || net.ParseIP(domain) != nil {
+		return false
+	}
+	for i := 0; i < len(domain); i++ {
+		c := domain[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,226 @@
+package route
+
+import (
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+// DomainSet 是只读的域名集合，内部以反转域名构建的简洁字典树 (LOUDS 编码) 存储，
+// 每个节点仅占数个比特，数万条规则在手机上也只需几百 KB 内存
+type DomainSet struct {
+	suffix *succinctSet // 后缀匹配: 命中自身与所有子域名
+	exact  *succinctSet // 完整匹配
+	size   int
+}
+
+// DomainSetBuilder 收集域名并生成 DomainSet
+type DomainSetBuilder struct {
+	suffix map[string]struct{}
+	exact  map[string]struct{}
+}
+
+// NewDomainSetBuilder 创建空的构建器
+func NewDomainSetBuilder() *DomainSetBuilder {
+	return &DomainSetBuilder{suffix: make(map[string]struct{}), exact: make(map[string]struct{})}
+}
+
+// AddSuffix 添加后缀规则，"example.com" 同时匹配其自身与子域名
+func (b *DomainSetBuilder) AddSuffix(domain string) {
+	if domain = normalizeDomain(strings.TrimLeft(domain, "*.")); domain != "" {
+		b.suffix[reverseDomain(domain)] = struct{}{}
+	}
+}
+
+// AddExact 添加完整域名规则
+func (b *DomainSetBuilder) AddExact(domain string) {
+	if domain = normalizeDomain(domain); domain != "" {
+		b.exact[reverseDomain(domain)] = struct{}{}
+	}
+}
+
+// Build 生成只读集合
+func (b *DomainSetBuilder) Build() *DomainSet {
+	return &DomainSet{
+		suffix: newSuccinctSet(sortedKeys(b.suffix)),
+		exact:  newSuccinctSet(sortedKeys(b.exact)),
+		size:   len(b.suffix) + len(b.exact),
+	}
+}
+
+// Len 返回集合中的规则数
+func (s *DomainSet) Len() int {
+	return s.size
+}
+
+// Match 判断域名 (已规范化为小写、无末尾点) 是否命中集合
+func (s *DomainSet) Match(domain string) bool {
+	key := reverseDomain(domain)
+	return s.exact.has(key) || s.suffix.hasLabelPrefix(key)
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// reverseDomain 按字节反转域名，使后缀匹配变为前缀匹配 ("www.a.com" -> "moc.a.www")
+func reverseDomain(domain string) string {
+	b := make([]byte, len(domain))
+	for i := 0; i < len(domain); i++ {
+		b[len(domain)-1-i] = domain[i]
+	}
+	return string(b)
+}
+
+// succinctSet 以 LOUDS 编码的字典树存储有序字符串集合
+// labelBitmap 中每个节点依次为每条出边记一个 0，再以一个 1 结束；labels 按相同顺序保存边上的字节；
+// leaves 标记节点是否为某个键的结尾
+type succinctSet struct {
+	leaves      []uint64
+	labelBitmap []uint64
+	labels      []byte
+	ranks       []int32 // ranks[i] 为 labelBitmap 前 i 个字中 1 的个数
+	selects     []int32 // selects[i] 为第 i*64 个 1 的位置
+}
+
+// newSuccinctSet 按层序遍历构建，keys 须已排序且无重复
+func newSuccinctSet(keys []string) *succinctSet {
+	ss := &succinctSet{}
+	type span struct{ start, end, depth int }
+	queue := []span{{0, len(keys), 0}}
+	bitIdx := 0
+	for node := 0; node < len(queue); node++ {
+		sp := queue[node]
+		if sp.start < sp.end && len(keys[sp.start]) == sp.depth {
+			setBit(&ss.leaves, node)
+			sp.start++
+		}
+		for i := sp.start; i < sp.end; {
+			from := i
+			c := keys[from][sp.depth]
+			for i < sp.end && keys[i][sp.depth] == c {
+				i++
+			}
+			queue = append(queue, span{from, i, sp.depth + 1})
+			ss.labels = append(ss.labels, c)
+			growBits(&ss.labelBitmap, bitIdx) // 0 位
+			bitIdx++
+		}
+		setBit(&ss.labelBitmap, bitIdx)
+		bitIdx++
+	}
+	ss.initIndex()
+	return ss
+}
+
+func (ss *succinctSet) initIndex() {
+	ss.ranks = make([]int32, len(ss.labelBitmap)+1)
+	for i, w := range ss.labelBitmap {
+		ss.ranks[i+1] = ss.ranks[i] + int32(bits.OnesCount64(w))
+	}
+	ones := 0
+	for i, w := range ss.labelBitmap {
+		for ; w != 0; w &= w - 1 {
+			if ones%64 == 0 {
+				ss.selects = append(ss.selects, int32(i*64+bits.TrailingZeros64(w)))
+			}
+			ones++
+		}
+	}
+}
+
+// has 判断 key 是否在集合中
+func (ss *succinctSet) has(key string) bool {
+	node, bmIdx := 0, 0
+	for i := 0; i < len(key); i++ {
+		var ok bool
+		if node, bmIdx, ok = ss.child(node, bmIdx, key[i]); !ok {
+			return false
+		}
+	}
+	return getBit(ss.leaves, node)
+}
+
+// hasLabelPrefix 判断集合中是否有 key 的前缀，且该前缀恰好结束在标签边界 ("." 之前或 key 末尾)
+func (ss *succinctSet) hasLabelPrefix(key string) bool {
+	node, bmIdx := 0, 0
+	for i := 0; i < len(key); i++ {
+		var ok bool
+		if node, bmIdx, ok = ss.child(node, bmIdx, key[i]); !ok {
+			return false
+		}
+		if getBit(ss.leaves, node) && (i+1 == len(key) || key[i+1] == '.') {
+			return true
+		}
+	}
+	return false
+}
+
+// child 查找节点的出边 c，返回子节点编号及其在 labelBitmap 中的起始位置
+func (ss *succinctSet) child(node, bmIdx int, c byte) (int, int, bool) {
+	for ; ; bmIdx++ {
+		if getBit(ss.labelBitmap, bmIdx) {
+			return 0, 0, false
+		}
+		// bmIdx 之前共有 node 个 1，因此对应的标签下标为 bmIdx-node
+		label := ss.labels[bmIdx-node]
+		if label == c {
+			break
+		}
+		if label > c { // 同一节点的标签有序
+			return 0, 0, false
+		}
+	}
+	// 子节点编号 = 该 0 位之前 (含) 的 0 的个数
+	child := bmIdx + 1 - ss.rank1(bmIdx+1)
+	return child, ss.select1(child-1) + 1, true
+}
+
+// rank1 返回 labelBitmap 中 [0, i) 内 1 的个数
+func (ss *succinctSet) rank1(i int) int {
+	w := i >> 6
+	r := int(ss.ranks[w])
+	if off := uint(i & 63); off > 0 {
+		r += bits.OnesCount64(ss.labelBitmap[w] & (1<<off - 1))
+	}
+	return r
+}
+
+// select1 返回第 i 个 (从 0 开始) 1 的位置
+func (ss *succinctSet) select1(i int) int {
+	pos := int(ss.selects[i>>6])
+	w := pos >> 6
+	remain := i - int(ss.ranks[w])
+	for {
+		word := ss.labelBitmap[w]
+		if n := bits.OnesCount64(word); remain >= n {
+			remain -= n
+			w++
+			continue
+		}
+		for ; remain > 0; remain-- {
+			word &= word - 1
+		}
+		return w*64 + bits.TrailingZeros64(word)
+	}
+}
+
+func growBits(bm *[]uint64, i int) {
+	for i>>6 >= len(*bm) {
+		*bm = append(*bm, 0)
+	}
+}
+
+func setBit(bm *[]uint64, i int) {
+	growBits(bm, i)
+	(*bm)[i>>6] |= 1 << uint(i&63)
+}
+
+func getBit(bm []uint64, i int) bool {
+	return i>>6 < len(bm) && bm[i>>6]&(1<<uint(i&63)) != 0
+}
@@ -0,0 +1,11 @@
+package route
+
+// UnknownUID 表示无法确定连接所属的应用
+const UnknownUID = -1
+
+// OwnerResolver 查询连接所属的应用
+// network 为 "tcp" / "udp"，source 与 destination 为 "ip:port" 格式 (source 为应用一侧的地址)
+// 无法确定时 uid 返回 UnknownUID；packageName 在平台不支持时可为空
+type OwnerResolver interface {
+	ResolveOwner(network, source, destination string) (uid int, packageName string)
+}
@@ -0,0 +1,97 @@
+//go:build linux && !android
+
+package route
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// procOwnerResolver 通过 /proc/net 查找本机套接字的所属用户，供桌面 Linux 调试按应用分流
+// 包名以用户名代替
+type procOwnerResolver struct{}
+
+// NewProcOwnerResolver 创建基于 /proc/net 的连接归属查询
+func NewProcOwnerResolver() OwnerResolver {
+	return procOwnerResolver{}
+}
+
+func (procOwnerResolver) ResolveOwner(network, source, destination string) (int, string) {
+	host, portStr, err := net.SplitHostPort(source)
+	if err != nil {
+		return UnknownUID, ""
+	}
+	ip := net.ParseIP(host)
+	port, err := strconv.Atoi(portStr)
+	if ip == nil || err != nil {
+		return UnknownUID, ""
+	}
+
+	files := []string{"/proc/net/" + network, "/proc/net/" + network + "6"}
+	for _, path := range files {
+		if uid, ok := findSocketUID(path, ip, port); ok {
+			name := strconv.Itoa(uid)
+			if u, err := user.LookupId(name); err == nil {
+				name = u.Username
+			}
+			return uid, name
+		}
+	}
+	return UnknownUID, ""
+}
+
+// findSocketUID 在 /proc/net/{tcp,udp}[6] 中按本地地址查找套接字的 UID
+func findSocketUID(path string, ip net.IP, port int) (int, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // 表头
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 {
+			continue
+		}
+		addr, portHex, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			continue
+		}
+		p, err := strconv.ParseUint(portHex, 16, 16)
+		if err != nil || int(p) != port {
+			continue
+		}
+		local := parseProcIP(addr)
+		if local == nil || !(local.Equal(ip) || local.IsUnspecified()) {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[7])
+		if err != nil {
+			continue
+		}
+		return uid, true
+	}
+	return 0, false
+}
+
+// parseProcIP 解析 /proc/net 中按主机字节序 (每 4 字节一组) 编码的十六进制地址
+func parseProcIP(s string) net.IP {
+	b, err := hex.DecodeString(s)
+	if err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return nil
+	}
+	ip := make(net.IP, len(b))
+	for i := 0; i < len(b); i += 4 {
+		binary.BigEndian.PutUint32(ip[i:], binary.LittleEndian.Uint32(b[i:]))
+	}
+	return ip
+}
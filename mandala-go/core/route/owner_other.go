@@ -0,0 +1,9 @@
+//go:build !linux || android
+
+package route
+
+// NewProcOwnerResolver 仅在桌面 Linux 上可用，其他平台返回 nil
+// Android 上由应用层通过 mobile.SetConnectionOwnerResolver 提供
+func NewProcOwnerResolver() OwnerResolver {
+	return nil
+}
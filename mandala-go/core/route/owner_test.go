@@ -0,0 +1,69 @@
+package route
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"mandala/core/config"
+	"mandala/core/protocol"
+)
+
+type namedOutbound string
+
+func (namedOutbound) DialTCP(ctx context.Context, dest protocol.Destination) (net.Conn, error) {
+	return nil, nil
+}
+
+func (namedOutbound) DialUDP(ctx context.Context, dest protocol.Destination) (net.Conn, error) {
+	return nil, nil
+}
+
+func TestRouterOwnerRules(t *testing.T) {
+	r, err := NewRouter(&config.RouteConfig{
+		Rules: []config.RuleConfig{
+			{PackageName: []string{"com.example.bank"}, Action: "direct"},
+			{UID: []int{10077}, Network: []string{"udp"}, Action: "block"},
+			{PackageName: []string{"com.example.video"}, UID: []int{10088}, Action: "proxy:streaming"},
+		},
+	}, namedOutbound("proxy"), map[string]protocol.Outbound{"streaming": namedOutbound("streaming")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.NeedsOwner() {
+		t.Fatal("router with app rules must need owner")
+	}
+
+	cases := []struct {
+		network string
+		uid     int
+		pkg     string
+		want    string
+	}{
+		{"tcp", 10050, "com.example.bank", "direct"},
+		{"tcp", 10077, "com.example.game", "proxy"}, // block 规则仅限 UDP
+		{"udp", 10077, "com.example.game", "block"},
+		{"tcp", 10066, "com.example.video", "proxy:streaming"},
+		{"tcp", 10088, "", "proxy:streaming"}, // 包名未知时按 UID 命中
+		{"tcp", UnknownUID, "", "proxy"},
+	}
+	for _, c := range cases {
+		m := NewMetadata(c.network, InboundTun, protocol.Destination{Host: "203.0.113.10", Port: 443})
+		m.UID, m.Package = c.uid, c.pkg
+		if got := r.Match(m).String(); got != c.want {
+			t.Errorf("%s uid=%d pkg=%q: got %s, want %s", c.network, c.uid, c.pkg, got, c.want)
+		}
+	}
+}
+
+func TestRouterWithoutOwnerRulesSkipsResolver(t *testing.T) {
+	r, err := NewRouter(&config.RouteConfig{
+		Rules: []config.RuleConfig{{DomainSuffix: []string{"example.com"}, Action: "direct"}},
+	}, namedOutbound("proxy"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.NeedsOwner() {
+		t.Fatal("router without app rules must not need owner")
+	}
+}
@@ -0,0 +1,224 @@
+package route
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"mandala/core/config"
+	"mandala/core/protocol"
+)
+
+// 入站类型，对应规则中的 inbound 字段
+const (
+	InboundTun    = "tun"
+	InboundSocks4 = "socks4"
+	InboundSocks5 = "socks5"
+	InboundHTTP   = "http"
+)
+
+// ErrBlocked 表示连接被路由规则拦截
+var ErrBlocked = errors.New("blocked by route rule")
+
+// Metadata 描述一条待路由的连接
+type Metadata struct {
+	Network string // "tcp" / "udp"
+	Inbound string // 入站类型
+	Domain  string // 目标域名 (目标为 IP 时为空)
+	IP      net.IP // 目标 IP (目标为域名时为 nil)
+	Port    int
+
+	// 连接归属，仅在规则需要且设置了 OwnerResolver 时填充
+	UID     int    // 未知时为 UnknownUID
+	Package string // 应用包名，未知时为空
+}
+
+// NewMetadata 根据目标地址构造路由元数据
+func NewMetadata(network, inbound string, dest protocol.Destination) *Metadata {
+	m := &Metadata{Network: network, Inbound: inbound, Port: dest.Port, UID: UnknownUID}
+	if ip := net.ParseIP(dest.Host); ip != nil {
+		m.IP = ip
+	} else {
+		m.Domain = normalizeDomain(dest.Host)
+	}
+	return m
+}
+
+func (m *Metadata) String() string {
+	host := m.Domain
+	if host == "" {
+		host = m.IP.String()
+	}
+	s := fmt.Sprintf("%s/%s %s", m.Inbound, m.Network, net.JoinHostPort(host, fmt.Sprint(m.Port)))
+	if m.Package != "" {
+		s += " (" + m.Package + ")"
+	} else if m.UID != UnknownUID {
+		s += fmt.Sprintf(" (uid %d)", m.UID)
+	}
+	return s
+}
+
+// ActionType 路由动作类型
+type ActionType int
+
+const (
+	ActionProxy ActionType = iota
+	ActionDirect
+	ActionBlock
+)
+
+// Action 路由结果，Tag 仅在 ActionProxy 时有效 (为空表示默认代理)
+type Action struct {
+	Type ActionType
+	Tag  string
+}
+
+func (a Action) String() string {
+	switch a.Type {
+	case ActionDirect:
+		return "direct"
+	case ActionBlock:
+		return "block"
+	}
+	if a.Tag != "" {
+		return "proxy:" + a.Tag
+	}
+	return "proxy"
+}
+
+// ParseAction 解析 "direct" / "block" / "proxy" / "proxy:<tag>"
+func ParseAction(s string) (Action, error) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "", "proxy":
+		return Action{Type: ActionProxy}, nil
+	case "direct":
+		return Action{Type: ActionDirect}, nil
+	case "block", "reject":
+		return Action{Type: ActionBlock}, nil
+	}
+	if len(s) > len("proxy:") && strings.EqualFold(s[:len("proxy:")], "proxy:") {
+		return Action{Type: ActionProxy, Tag: s[len("proxy:"):]}, nil
+	}
+	return Action{}, fmt.Errorf("unknown route action: %s", s)
+}
+
+// Router 按规则为连接选择出站
+type Router struct {
+	rules      []*rule
+	final      Action
+	needsOwner bool // 存在应用条件的规则
+
+	direct protocol.Outbound
+	proxy  protocol.Outbound            // 默认代理出站
+	tagged map[string]protocol.Outbound // 按标签索引的出站
+}
+
+// NewRouter 创建路由器
+// proxy 为默认代理出站，tagged 提供 "proxy:<tag>" 可引用的出站；cfg 为 nil 时全部走默认代理
+func NewRouter(cfg *config.RouteConfig, proxy protocol.Outbound, tagged map[string]protocol.Outbound) (*Router, error) {
+	r := &Router{
+		direct: protocol.NewDirectOutbound(),
+		proxy:  proxy,
+		tagged: tagged,
+	}
+	if cfg == nil {
+		return r, nil
+	}
+
+	var err error
+	if r.final, err = r.parseAction(cfg.Final); err != nil {
+		return nil, err
+	}
+	for i := range cfg.Rules {
+		rl, err := newRule(&cfg.Rules[i])
+		if err != nil {
+			return nil, fmt.Errorf("route rule %d: %v", i, err)
+		}
+		if rl.action, err = r.parseAction(cfg.Rules[i].Action); err != nil {
+			return nil, fmt.Errorf("route rule %d: %v", i, err)
+		}
+		r.rules = append(r.rules, rl)
+		r.needsOwner = r.needsOwner || rl.hasOwner()
+	}
+	log.Printf("[Route] 已加载 %d 条路由规则, 默认动作: %s", len(r.rules), r.final)
+	return r, nil
+}
+
+// parseAction 解析动作并校验引用的出站标签存在
+func (r *Router) parseAction(s string) (Action, error) {
+	a, err := ParseAction(s)
+	if err != nil {
+		return a, err
+	}
+	if a.Type == ActionProxy && a.Tag != "" {
+		if _, ok := r.tagged[a.Tag]; !ok {
+			return a, fmt.Errorf("unknown outbound tag: %s", a.Tag)
+		}
+	}
+	return a, nil
+}
+
+// NeedsOwner 判断路由是否需要连接归属信息，用于避免不必要的查询
+func (r *Router) NeedsOwner() bool {
+	return r.needsOwner
+}
+
+// Match 返回首个命中规则的动作，未命中时返回默认动作
+func (r *Router) Match(m *Metadata) Action {
+	for _, rl := range r.rules {
+		if rl.match(m) {
+			return rl.action
+		}
+	}
+	return r.final
+}
+
+// BlocksDomain 判断域名是否被拦截，供 DNS 在查询阶段直接拒绝
+// 仅考虑只含域名类条件的规则，按顺序以首个命中者的动作为准；默认动作不参与判定
+func (r *Router) BlocksDomain(domain string) bool {
+	m := &Metadata{Domain: normalizeDomain(domain), UID: UnknownUID}
+	for _, rl := range r.rules {
+		if rl.domainOnly() && rl.matchAddress(m) {
+			return rl.action.Type == ActionBlock
+		}
+	}
+	return false
+}
+
+// Pick 为连接选择出站，命中 block 时返回 ErrBlocked
+func (r *Router) Pick(m *Metadata) (protocol.Outbound, error) {
+	action := r.Match(m)
+	if action.Type == ActionBlock {
+		log.Printf("[Route] 拦截: %s", m)
+		return nil, ErrBlocked
+	}
+	return r.outboundFor(action)
+}
+
+// Outbound 按动作字符串 ("proxy" / "direct" / "proxy:<tag>") 返回出站，供 DNS 等模块复用
+func (r *Router) Outbound(action string) (protocol.Outbound, error) {
+	a, err := r.parseAction(action)
+	if err != nil {
+		return nil, err
+	}
+	if a.Type == ActionBlock {
+		return nil, fmt.Errorf("action %s has no outbound", a)
+	}
+	return r.outboundFor(a)
+}
+
+func (r *Router) outboundFor(a Action) (protocol.Outbound, error) {
+	if a.Type == ActionDirect {
+		return r.direct, nil
+	}
+	if a.Tag != "" {
+		return r.tagged[a.Tag], nil
+	}
+	if r.proxy == nil {
+		return nil, fmt.Errorf("no proxy outbound configured")
+	}
+	return r.proxy, nil
+}
@@ -0,0 +1,244 @@
+package route
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"mandala/core/config"
+)
+
+// rule 是编译后的单条路由规则
+type rule struct {
+	domains  map[string]struct{}
+	suffixes []string
+	keywords []string
+	regexes  []*regexp.Regexp
+	lists    []*DomainSet
+	cidrs    []*net.IPNet
+
+	ports    []portRange
+	networks map[string]struct{}
+	inbounds map[string]struct{}
+
+	// 应用条件: 包名与 UID 任一命中即可
+	packages map[string]struct{}
+	uids     map[int]struct{}
+
+	action Action
+}
+
+type portRange struct {
+	from, to int
+}
+
+func newRule(cfg *config.RuleConfig) (*rule, error) {
+	r := &rule{}
+
+	for _, d := range cfg.Domain {
+		if r.domains == nil {
+			r.domains = make(map[string]struct{})
+		}
+		r.domains[normalizeDomain(d)] = struct{}{}
+	}
+	for _, d := range cfg.DomainSuffix {
+		r.suffixes = append(r.suffixes, strings.TrimPrefix(normalizeDomain(d), "."))
+	}
+	for _, k := range cfg.DomainKeyword {
+		r.keywords = append(r.keywords, strings.ToLower(k))
+	}
+	for _, expr := range cfg.DomainRegex {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid domain_regex %q: %v", expr, err)
+		}
+		r.regexes = append(r.regexes, re)
+	}
+	for _, path := range cfg.DomainList {
+		set, err := LoadDomainList(path)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[Route] 已加载域名列表 %s: %d 条", path, set.Len())
+		r.lists = append(r.lists, set)
+	}
+	for _, c := range cfg.IPCIDR {
+		if !strings.Contains(c, "/") {
+			if ip := net.ParseIP(c); ip != nil && ip.To4() != nil {
+				c += "/32"
+			} else {
+				c += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ip_cidr %q: %v", c, err)
+		}
+		r.cidrs = append(r.cidrs, ipNet)
+	}
+	for _, p := range cfg.Port {
+		pr, err := parsePortRange(p)
+		if err != nil {
+			return nil, err
+		}
+		r.ports = append(r.ports, pr)
+	}
+	for _, n := range cfg.Network {
+		if r.networks == nil {
+			r.networks = make(map[string]struct{})
+		}
+		r.networks[strings.ToLower(n)] = struct{}{}
+	}
+	for _, in := range cfg.Inbound {
+		if r.inbounds == nil {
+			r.inbounds = make(map[string]struct{})
+		}
+		in = strings.ToLower(in)
+		if in == "socks" {
+			r.inbounds[InboundSocks4] = struct{}{}
+			r.inbounds[InboundSocks5] = struct{}{}
+			continue
+		}
+		r.inbounds[in] = struct{}{}
+	}
+	for _, pkg := range cfg.PackageName {
+		if r.packages == nil {
+			r.packages = make(map[string]struct{})
+		}
+		r.packages[strings.TrimSpace(pkg)] = struct{}{}
+	}
+	for _, uid := range cfg.UID {
+		if r.uids == nil {
+			r.uids = make(map[int]struct{})
+		}
+		r.uids[uid] = struct{}{}
+	}
+	return r, nil
+}
+
+func parsePortRange(s string) (portRange, error) {
+	s = strings.TrimSpace(s)
+	from, to, isRange := strings.Cut(s, "-")
+	start, err := strconv.Atoi(strings.TrimSpace(from))
+	if err != nil {
+		return portRange{}, fmt.Errorf("invalid port %q", s)
+	}
+	end := start
+	if isRange {
+		if end, err = strconv.Atoi(strings.TrimSpace(to)); err != nil {
+			return portRange{}, fmt.Errorf("invalid port %q", s)
+		}
+	}
+	if start < 0 || end > 65535 || start > end {
+		return portRange{}, fmt.Errorf("invalid port %q", s)
+	}
+	return portRange{from: start, to: end}, nil
+}
+
+// match 判断连接是否命中该规则
+func (r *rule) match(m *Metadata) bool {
+	if r.hasAddress() && !r.matchAddress(m) {
+		return false
+	}
+	if len(r.ports) > 0 && !r.matchPort(m.Port) {
+		return false
+	}
+	if r.networks != nil {
+		if _, ok := r.networks[m.Network]; !ok {
+			return false
+		}
+	}
+	if r.inbounds != nil {
+		if _, ok := r.inbounds[m.Inbound]; !ok {
+			return false
+		}
+	}
+	if r.hasOwner() && !r.matchOwner(m) {
+		return false
+	}
+	return true
+}
+
+func (r *rule) hasOwner() bool {
+	return r.packages != nil || r.uids != nil
+}
+
+// matchOwner 应用条件: 归属未知的连接不命中
+func (r *rule) matchOwner(m *Metadata) bool {
+	if m.UID != UnknownUID {
+		if _, ok := r.uids[m.UID]; ok {
+			return true
+		}
+	}
+	if m.Package != "" {
+		if _, ok := r.packages[m.Package]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *rule) hasAddress() bool {
+	return r.domains != nil || len(r.suffixes) > 0 || len(r.keywords) > 0 ||
+		len(r.regexes) > 0 || len(r.lists) > 0 || len(r.cidrs) > 0
+}
+
+// domainOnly 判断规则是否仅含域名类条件，此类规则在 DNS 查询阶段即可判定
+func (r *rule) domainOnly() bool {
+	return r.hasAddress() && len(r.cidrs) == 0 && len(r.ports) == 0 &&
+		r.networks == nil && r.inbounds == nil && !r.hasOwner()
+}
+
+// matchAddress 目标地址条件: 域名类与 IP 段任一命中即可
+func (r *rule) matchAddress(m *Metadata) bool {
+	if m.Domain != "" {
+		if _, ok := r.domains[m.Domain]; ok {
+			return true
+		}
+		for _, suffix := range r.suffixes {
+			if m.Domain == suffix || strings.HasSuffix(m.Domain, "."+suffix) {
+				return true
+			}
+		}
+		for _, k := range r.keywords {
+			if strings.Contains(m.Domain, k) {
+				return true
+			}
+		}
+		for _, re := range r.regexes {
+			if re.MatchString(m.Domain) {
+				return true
+			}
+		}
+		for _, set := range r.lists {
+			if set.Match(m.Domain) {
+				return true
+			}
+		}
+	}
+	if m.IP != nil {
+		for _, c := range r.cidrs {
+			if c.Contains(m.IP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *rule) matchPort(port int) bool {
+	for _, pr := range r.ports {
+		if port >= pr.from && port <= pr.to {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeDomain 统一为小写并去掉末尾的点
+func normalizeDomain(d string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(d)), ".")
+}
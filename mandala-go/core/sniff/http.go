@@ -0,0 +1,60 @@
+package sniff
+
+import (
+	"bytes"
+	"net"
+	"strings"
+)
+
+var httpMethods = []string{"GET", "POST", "HEAD", "PUT", "DELETE", "OPTIONS", "PATCH", "CONNECT", "TRACE"}
+
+// HTTP 从 HTTP/1.x 请求头中解析 Host
+func HTTP(b []byte) (string, error) {
+	if !hasHTTPMethod(b) {
+		if couldBeHTTPMethod(b) {
+			return "", ErrNeedMore
+		}
+		return "", ErrNotMatched
+	}
+
+	// 仅处理已完整接收的行，首行为请求行
+	lines := bytes.Split(b, []byte("\r\n"))
+	complete := lines[:len(lines)-1]
+	for i, line := range complete {
+		if i == 0 {
+			continue
+		}
+		if len(line) == 0 {
+			// 请求头结束仍未找到 Host (HTTP/1.0)
+			return "", ErrNotMatched
+		}
+		k, v, ok := strings.Cut(string(line), ":")
+		if ok && strings.EqualFold(strings.TrimSpace(k), "host") {
+			host := strings.TrimSpace(v)
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			return validDomain(host)
+		}
+	}
+	return "", ErrNeedMore
+}
+
+func hasHTTPMethod(b []byte) bool {
+	for _, m := range httpMethods {
+		if len(b) > len(m) && string(b[:len(m)]) == m && b[len(m)] == ' ' {
+			return true
+		}
+	}
+	return false
+}
+
+// couldBeHTTPMethod 判断不完整的数据是否可能是请求方法的前缀
+func couldBeHTTPMethod(b []byte) bool {
+	for _, m := range httpMethods {
+		if len(b) <= len(m) && strings.HasPrefix(m+" ", string(b)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,241 @@
+package sniff
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"sort"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// QUIC 版本及其 Initial 密钥派生参数 (RFC 9001 5.2, RFC 9369 3.3)
+const (
+	quicVersion1       = 0x00000001
+	quicVersion2       = 0x6b3343cf
+	quicVersionDraft29 = 0xff00001d
+)
+
+var (
+	initialSaltV1      = []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a}
+	initialSaltV2      = []byte{0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93, 0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9}
+	initialSaltDraft29 = []byte{0xaf, 0xbf, 0xec, 0x28, 0x99, 0x93, 0xd2, 0x4c, 0x9e, 0x97, 0x86, 0xf1, 0x9c, 0x61, 0x11, 0xe0, 0x43, 0x90, 0xa8, 0x99}
+)
+
+// QUIC 解密客户端 Initial 包并从 CRYPTO 帧重组的 ClientHello 中提取 SNI
+// packets 为按接收顺序排列的数据报，ClientHello 可能跨多个 Initial 包
+func QUIC(packets [][]byte) (string, error) {
+	var fragments []cryptoFragment
+	for _, p := range packets {
+		// 一个数据报中可能合并了多个长包头数据包
+		for len(p) > 0 {
+			frags, rest, err := decryptInitial(p)
+			if err != nil {
+				if len(fragments) > 0 {
+					break // 合并在后面的非 Initial 包
+				}
+				return "", err
+			}
+			fragments = append(fragments, frags...)
+			p = rest
+		}
+	}
+	if len(fragments) == 0 {
+		return "", ErrNotMatched
+	}
+
+	// 按偏移重组从 0 开始的连续数据
+	sort.Slice(fragments, func(i, j int) bool { return fragments[i].offset < fragments[j].offset })
+	var hello []byte
+	for _, f := range fragments {
+		end := f.offset + uint64(len(f.data))
+		if f.offset > uint64(len(hello)) {
+			break
+		}
+		if end > uint64(len(hello)) {
+			hello = append(hello, f.data[uint64(len(hello))-f.offset:]...)
+		}
+	}
+	if len(hello) == 0 {
+		return "", ErrNeedMore
+	}
+	return clientHelloSNI(hello)
+}
+
+type cryptoFragment struct {
+	offset uint64
+	data   []byte
+}
+
+// decryptInitial 解析并解密一个 Initial 包，返回其中的 CRYPTO 帧与数据报中剩余的字节
+func decryptInitial(p []byte) ([]cryptoFragment, []byte, error) {
+	// 长包头: 标志(1) 版本(4) DCID长度(1) DCID SCID长度(1) SCID
+	if len(p) < 7 || p[0]&0x80 == 0 {
+		return nil, nil, ErrNotMatched
+	}
+	version := binary.BigEndian.Uint32(p[1:5])
+	var salt []byte
+	var packetType byte
+	keyLabel, ivLabel, hpLabel := "quic key", "quic iv", "quic hp"
+	switch version {
+	case quicVersion1:
+		salt, packetType = initialSaltV1, 0
+	case quicVersionDraft29:
+		salt, packetType = initialSaltDraft29, 0
+	case quicVersion2:
+		salt, packetType = initialSaltV2, 1
+		keyLabel, ivLabel, hpLabel = "quicv2 key", "quicv2 iv", "quicv2 hp"
+	default:
+		return nil, nil, ErrNotMatched
+	}
+	if (p[0]&0x30)>>4 != packetType {
+		return nil, nil, ErrNotMatched
+	}
+
+	r := reader(p[5:])
+	dcid, ok := r.vector8()
+	if !ok || len(dcid) > 20 {
+		return nil, nil, ErrNotMatched
+	}
+	if _, ok := r.vector8(); !ok { // SCID
+		return nil, nil, ErrNotMatched
+	}
+	tokenLen, ok := r.varint()
+	if !ok || !r.skip(int(tokenLen)) {
+		return nil, nil, ErrNotMatched
+	}
+	length, ok := r.varint()
+	if !ok || uint64(len(r)) < length || length < 20 {
+		return nil, nil, ErrNotMatched
+	}
+	pnOffset := len(p) - len(r)
+	packetEnd := pnOffset + int(length)
+
+	// 派生客户端 Initial 密钥
+	initialSecret := hkdf.Extract(sha256.New, dcid, salt)
+	clientSecret := expandLabel(initialSecret, "client in", 32)
+	key := expandLabel(clientSecret, keyLabel, 16)
+	iv := expandLabel(clientSecret, ivLabel, 12)
+	hp := expandLabel(clientSecret, hpLabel, 16)
+
+	// 移除包头保护 (RFC 9001 5.4)，不修改调用方的数据
+	header := append([]byte(nil), p[:pnOffset+4]...)
+	block, err := aes.NewCipher(hp)
+	if err != nil {
+		return nil, nil, ErrNotMatched
+	}
+	mask := make([]byte, aes.BlockSize)
+	block.Encrypt(mask, p[pnOffset+4:pnOffset+4+aes.BlockSize])
+	header[0] ^= mask[0] & 0x0f
+	pnLen := int(header[0]&0x03) + 1
+	var pn uint64
+	for i := 0; i < pnLen; i++ {
+		header[pnOffset+i] ^= mask[1+i]
+		pn = pn<<8 | uint64(header[pnOffset+i])
+	}
+	header = header[:pnOffset+pnLen]
+
+	block, err = aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, ErrNotMatched
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, ErrNotMatched
+	}
+	nonce := append([]byte(nil), iv...)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+	payload, err := aead.Open(nil, nonce, p[pnOffset+pnLen:packetEnd], header)
+	if err != nil {
+		return nil, nil, ErrNotMatched
+	}
+
+	frags, err := cryptoFrames(payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	return frags, p[packetEnd:], nil
+}
+
+// cryptoFrames 从 Initial 包的明文中提取 CRYPTO 帧
+// 客户端 Initial 包中只会出现 PADDING / PING / ACK / CRYPTO / CONNECTION_CLOSE
+func cryptoFrames(b reader) ([]cryptoFragment, error) {
+	var frags []cryptoFragment
+	for len(b) > 0 {
+		frameType, ok := b.varint()
+		if !ok {
+			return nil, ErrNotMatched
+		}
+		switch frameType {
+		case 0x00, 0x01: // PADDING, PING
+		case 0x02, 0x03: // ACK
+			var count uint64
+			ok := true
+			for i := 0; i < 4 && ok; i++ { // largest, delay, range count, first range
+				var v uint64
+				v, ok = b.varint()
+				if i == 2 {
+					count = v
+				}
+			}
+			for i := uint64(0); i < count*2 && ok; i++ {
+				_, ok = b.varint()
+			}
+			if frameType == 0x03 {
+				for i := 0; i < 3 && ok; i++ { // ECN 计数
+					_, ok = b.varint()
+				}
+			}
+			if !ok {
+				return nil, ErrNotMatched
+			}
+		case 0x06: // CRYPTO
+			offset, ok1 := b.varint()
+			length, ok2 := b.varint()
+			data, ok3 := b.bytes(int(length))
+			if !ok1 || !ok2 || !ok3 {
+				return nil, ErrNotMatched
+			}
+			frags = append(frags, cryptoFragment{offset: offset, data: data})
+		case 0x1c: // CONNECTION_CLOSE
+			return nil, ErrNotMatched
+		default:
+			return nil, ErrNotMatched
+		}
+	}
+	return frags, nil
+}
+
+// expandLabel TLS 1.3 HKDF-Expand-Label (RFC 8446 7.1)，上下文为空
+func expandLabel(secret []byte, label string, length int) []byte {
+	full := "tls13 " + label
+	info := make([]byte, 0, 4+len(full))
+	info = binary.BigEndian.AppendUint16(info, uint16(length))
+	info = append(info, byte(len(full)))
+	info = append(info, full...)
+	info = append(info, 0)
+	out := make([]byte, length)
+	io.ReadFull(hkdf.Expand(sha256.New, secret, info), out)
+	return out
+}
+
+// varint 读取 QUIC 变长整数 (RFC 9000 16)
+func (r *reader) varint() (uint64, bool) {
+	if len(*r) == 0 {
+		return 0, false
+	}
+	n := 1 << ((*r)[0] >> 6)
+	if len(*r) < n {
+		return 0, false
+	}
+	v := uint64((*r)[0] & 0x3f)
+	for i := 1; i < n; i++ {
+		v = v<<8 | uint64((*r)[i])
+	}
+	*r = (*r)[n:]
+	return v, true
+}
@@ -0,0 +1,122 @@
+package sniff
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// RFC 9001 附录 A.2 客户端 Initial 包中的 CRYPTO 帧 (ClientHello, SNI 为 example.com)
+const rfc9001CryptoFrame = `
+060040f1010000ed0303ebf8fa56f12939b9584a3896472ec40bb863cfd3e868
+04fe3a47f06a2b69484c00000413011302010000c000000010000e00000b6578
+616d706c652e636f6dff01000100000a00080006001d00170018001000070005
+04616c706e000500050100000000003300260024001d00209370b2c9caa47fba
+baf4559fedba753de171fa71f50f1ce15d43e994ec74d748002b000302030400
+0d0010000e0403050306030203080408050806002d00020101001c0002400100
+3900320408ffffffffffffffff05048000ffff07048000ffff08011001048000
+75300901100f088394c8f03e51570806048000ffff`
+
+// rfc9001ClientInitial 按 RFC 9001 附录 A.2 的步骤构造受保护的客户端 Initial 包
+func rfc9001ClientInitial(t *testing.T) []byte {
+	t.Helper()
+	key := unhex(t, "1f369613dd76d5467730efcbe3b1a22d")
+	iv := unhex(t, "fa044b2f42a3fd3b46fb255c")
+	hp := unhex(t, "9f50449e04a0e810283a1e9933adedd2")
+	header := unhex(t, "c300000001088394c8f03e5157080000449e00000002")
+	const pnOffset, pn = 18, 2
+
+	// 明文以 PADDING 补足到 1162 字节，加上包头与认证标签共 1200 字节
+	payload := make([]byte, 1162)
+	copy(payload, unhex(t, rfc9001CryptoFrame))
+
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	nonce := append([]byte(nil), iv...)
+	nonce[len(nonce)-1] ^= pn
+	packet := aead.Seal(append([]byte(nil), header...), nonce, payload, header)
+
+	block, _ = aes.NewCipher(hp)
+	mask := make([]byte, aes.BlockSize)
+	block.Encrypt(mask, packet[pnOffset+4:pnOffset+4+aes.BlockSize])
+	packet[0] ^= mask[0] & 0x0f
+	for i := 0; i < 4; i++ {
+		packet[pnOffset+i] ^= mask[1+i]
+	}
+	return packet
+}
+
+func TestQUICInitialKeys(t *testing.T) {
+	// RFC 9001 附录 A.1
+	secret := unhex(t, "c00cf151ca5be075ed0ebfb5c80323c42d6b7db67881289af4008f1f6c357aea")
+	for _, c := range []struct {
+		label string
+		want  string
+	}{
+		{"quic key", "1f369613dd76d5467730efcbe3b1a22d"},
+		{"quic iv", "fa044b2f42a3fd3b46fb255c"},
+		{"quic hp", "9f50449e04a0e810283a1e9933adedd2"},
+	} {
+		want := unhex(t, c.want)
+		if got := expandLabel(secret, c.label, len(want)); !bytes.Equal(got, want) {
+			t.Errorf("%s = %x, want %x", c.label, got, want)
+		}
+	}
+}
+
+func TestQUICRFC9001ClientInitial(t *testing.T) {
+	packet := rfc9001ClientInitial(t)
+
+	// 与 RFC 9001 附录 A.2 给出的受保护数据包首尾比对，确认构造结果即标准向量
+	prefix := unhex(t, "c000000001088394c8f03e5157080000449e7b9aec34d1b1c98dd7689fb8ec11d242b123dc9b")
+	suffix := unhex(t, "e221af44860018ab0856972e194cd934")
+	if len(packet) != 1200 || !bytes.HasPrefix(packet, prefix) || !bytes.HasSuffix(packet, suffix) {
+		t.Fatalf("packet does not match RFC 9001 A.2: %x...%x", packet[:len(prefix)], packet[len(packet)-len(suffix):])
+	}
+
+	domain, err := QUIC([][]byte{packet})
+	if err != nil || domain != "example.com" {
+		t.Fatalf("QUIC() = %q, %v", domain, err)
+	}
+
+	// 篡改密文后认证失败
+	packet[len(packet)-1] ^= 0xff
+	if _, err := QUIC([][]byte{packet}); err != ErrNotMatched {
+		t.Fatalf("tampered packet: err = %v", err)
+	}
+}
+
+func TestCryptoFramesTruncatedVarint(t *testing.T) {
+	for _, b := range []reader{
+		{0x00, 0x40},             // PADDING 后跟截断的帧类型
+		{0x06, 0x00, 0x80},       // CRYPTO 长度截断
+		{0x02, 0x00, 0x00, 0x40}, // ACK 字段截断
+	} {
+		done := make(chan error, 1)
+		go func(b reader) {
+			_, err := cryptoFrames(b)
+			done <- err
+		}(b)
+		select {
+		case err := <-done:
+			if err != ErrNotMatched {
+				t.Errorf("cryptoFrames(%x) err = %v, want ErrNotMatched", []byte(b), err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("cryptoFrames(%x) did not return", []byte(b))
+		}
+	}
+}
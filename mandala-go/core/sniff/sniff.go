@@ -0,0 +1,183 @@
+package sniff
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"mandala/core/config"
+)
+
+// 可嗅探的协议，对应配置中的 protocols / override 字段
+const (
+	ProtocolTLS  = "tls"
+	ProtocolHTTP = "http"
+	ProtocolQUIC = "quic"
+)
+
+var (
+	// ErrNeedMore 数据不足，需要读取更多内容后重试
+	ErrNeedMore = errors.New("sniff: need more data")
+	// ErrNotMatched 数据不属于该协议或其中没有域名
+	ErrNotMatched = errors.New("sniff: protocol not matched")
+)
+
+const (
+	defaultTimeout = 300 * time.Millisecond
+	// maxPeekSize 流式嗅探最多缓存的首包数据
+	maxPeekSize = 16 * 1024
+	// maxPackets QUIC 嗅探最多读取的数据报数 (ClientHello 可能跨多个 Initial 包)
+	maxPackets = 4
+)
+
+// Result 嗅探结果，Domain 为空表示未识别
+type Result struct {
+	Protocol string
+	Domain   string
+	Override bool // 是否以该域名替换连接目标
+}
+
+// Sniffer 按配置从客户端首包中识别目标域名
+type Sniffer struct {
+	protocols map[string]bool
+	override  map[string]bool
+	timeout   time.Duration
+}
+
+// New 根据配置创建嗅探器，cfg 为 nil 或未启用时返回 nil
+func New(cfg *config.SniffConfig) *Sniffer {
+	if cfg == nil || !cfg.Enabled {
+		return nil
+	}
+	s := &Sniffer{
+		protocols: make(map[string]bool),
+		override:  make(map[string]bool),
+		timeout:   defaultTimeout,
+	}
+	protocols := cfg.Protocols
+	if len(protocols) == 0 {
+		protocols = []string{ProtocolTLS, ProtocolHTTP, ProtocolQUIC}
+	}
+	for _, p := range protocols {
+		s.protocols[strings.ToLower(p)] = true
+	}
+	for _, p := range cfg.Override {
+		s.override[strings.ToLower(p)] = true
+	}
+	if cfg.Timeout > 0 {
+		s.timeout = time.Duration(cfg.Timeout) * time.Millisecond
+	}
+	log.Printf("[Sniff] 已启用: %v, 覆盖目标: %v", protocols, cfg.Override)
+	return s
+}
+
+// Enabled 判断是否嗅探指定协议
+func (s *Sniffer) Enabled(protocol string) bool {
+	return s != nil && s.protocols[protocol]
+}
+
+// SniffConn 读取 TCP 连接的首包识别 TLS SNI 或 HTTP Host
+// 返回的连接会先重放已读取的数据；超时未收到数据 (如服务端先发言的协议) 时结果为空
+func (s *Sniffer) SniffConn(conn net.Conn) (net.Conn, Result) {
+	var buf []byte
+	chunk := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(s.timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	for len(buf) < maxPeekSize {
+		n, err := conn.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+		if n > 0 {
+			result, serr := s.sniffStream(buf)
+			if serr != ErrNeedMore {
+				return newPeekedConn(conn, buf), result
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	return newPeekedConn(conn, buf), Result{}
+}
+
+// sniffStream 依次尝试已启用的流式协议，任一协议需要更多数据时返回 ErrNeedMore
+func (s *Sniffer) sniffStream(b []byte) (Result, error) {
+	needMore := false
+	for _, p := range []string{ProtocolTLS, ProtocolHTTP} {
+		if !s.protocols[p] {
+			continue
+		}
+		var domain string
+		var err error
+		if p == ProtocolTLS {
+			domain, err = TLS(b)
+		} else {
+			domain, err = HTTP(b)
+		}
+		switch err {
+		case nil:
+			return Result{Protocol: p, Domain: domain, Override: s.override[p]}, nil
+		case ErrNeedMore:
+			needMore = true
+		}
+	}
+	if needMore {
+		return Result{}, ErrNeedMore
+	}
+	return Result{}, ErrNotMatched
+}
+
+// SniffPackets 读取 UDP 连接的首批数据报识别 QUIC Initial 中的 SNI
+// 返回已读取的数据报，调用方需将其转发给远端
+func (s *Sniffer) SniffPackets(conn net.Conn) ([][]byte, Result) {
+	var packets [][]byte
+	conn.SetReadDeadline(time.Now().Add(s.timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	for len(packets) < maxPackets {
+		buf := make([]byte, 65535)
+		n, err := conn.Read(buf)
+		if err != nil {
+			break
+		}
+		packets = append(packets, buf[:n])
+		domain, err := QUIC(packets)
+		if err == nil {
+			return packets, Result{Protocol: ProtocolQUIC, Domain: domain, Override: s.override[ProtocolQUIC]}
+		}
+		if err != ErrNeedMore {
+			break
+		}
+	}
+	return packets, Result{}
+}
+
+// peekedConn 先返回已读取的数据，再继续读取底层连接
+type peekedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func newPeekedConn(conn net.Conn, peeked []byte) net.Conn {
+	if len(peeked) == 0 {
+		return conn
+	}
+	return &peekedConn{Conn: conn, r: io.MultiReader(bytes.NewReader(peeked), conn)}
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// validDomain 过滤空值与 IP 字面量
+func validDomain(host string) (string, error) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" || net.ParseIP(host) != nil {
+		return "", ErrNotMatched
+	}
+	return host, nil
+}
@@ -0,0 +1,103 @@
+package sniff
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"mandala/core/config"
+)
+
+// clientHello 返回 crypto/tls 客户端发出的第一条 TLS 记录
+func clientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer server.Close()
+	go tls.Client(client, &tls.Config{ServerName: serverName}).Handshake()
+	defer client.Close()
+
+	server.SetReadDeadline(time.Now().Add(time.Second))
+	head := make([]byte, 5)
+	if _, err := io.ReadFull(server, head); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, int(head[3])<<8|int(head[4]))
+	if _, err := io.ReadFull(server, body); err != nil {
+		t.Fatal(err)
+	}
+	return append(head, body...)
+}
+
+func TestTLS(t *testing.T) {
+	hello := clientHello(t, "www.example.com")
+
+	if domain, err := TLS(hello); err != nil || domain != "www.example.com" {
+		t.Fatalf("TLS() = %q, %v", domain, err)
+	}
+	if _, err := TLS(hello[:3]); err != ErrNeedMore {
+		t.Errorf("short header: err = %v, want ErrNeedMore", err)
+	}
+	if _, err := TLS(hello[:40]); err != ErrNeedMore {
+		t.Errorf("partial record: err = %v, want ErrNeedMore", err)
+	}
+
+	// ClientHello 拆分到两条记录中
+	body := hello[5:]
+	split := []byte{0x16, 0x03, 0x01, 0x00, 0x20}
+	split = append(split, body[:0x20]...)
+	split = append(split, 0x16, 0x03, 0x01, byte((len(body)-0x20)>>8), byte(len(body)-0x20))
+	split = append(split, body[0x20:]...)
+	if domain, err := TLS(split); err != nil || domain != "www.example.com" {
+		t.Errorf("split records: TLS() = %q, %v", domain, err)
+	}
+
+	// 不带 SNI (IP 目标) 的 ClientHello
+	if _, err := TLS(clientHello(t, "192.0.2.1")); err != ErrNotMatched {
+		t.Errorf("no sni: err = %v, want ErrNotMatched", err)
+	}
+	if _, err := TLS([]byte("GET / HTTP/1.1\r\n")); err != ErrNotMatched {
+		t.Errorf("http: err = %v, want ErrNotMatched", err)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	for _, c := range []struct {
+		req    string
+		domain string
+		err    error
+	}{
+		{"GET / HTTP/1.1\r\nHost: Example.com:8080\r\n\r\n", "example.com", nil},
+		{"POST /x HTTP/1.1\r\nUser-Agent: t\r\nhost: api.example.com\r\n", "api.example.com", nil},
+		{"GET / HTTP/1.1\r\nHost: 192.0.2.1\r\n\r\n", "", ErrNotMatched},
+		{"GET / HTTP/1.0\r\n\r\n", "", ErrNotMatched},
+		{"GET / HTTP/1.1\r\nHost: exam", "", ErrNeedMore},
+		{"PO", "", ErrNeedMore},
+		{"SSH-2.0-OpenSSH\r\n", "", ErrNotMatched},
+	} {
+		domain, err := HTTP([]byte(c.req))
+		if domain != c.domain || err != c.err {
+			t.Errorf("HTTP(%q) = %q, %v, want %q, %v", c.req, domain, err, c.domain, c.err)
+		}
+	}
+}
+
+func TestSniffConnReplaysData(t *testing.T) {
+	s := New(&config.SniffConfig{Enabled: true, Override: []string{ProtocolHTTP}})
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	req := "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	go client.Write([]byte(req))
+
+	conn, result := s.SniffConn(server)
+	if result.Protocol != ProtocolHTTP || result.Domain != "example.com" || !result.Override {
+		t.Fatalf("result = %+v", result)
+	}
+	buf := make([]byte, len(req))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != req {
+		t.Fatalf("replayed %q, %v", buf, err)
+	}
+}
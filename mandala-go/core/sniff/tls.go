@@ -0,0 +1,161 @@
+package sniff
+
+import "encoding/binary"
+
+const (
+	recordTypeHandshake      = 0x16
+	handshakeTypeClientHello = 0x01
+	extensionServerName      = 0x0000
+	serverNameTypeHostName   = 0x00
+	maxRecordLength          = 1 << 14
+)
+
+// TLS 从 TLS 记录层数据中解析 ClientHello 的 SNI，ClientHello 可跨多个记录
+func TLS(b []byte) (string, error) {
+	var handshake []byte
+	for len(b) > 0 {
+		if len(b) < 5 {
+			if len(handshake) > 0 {
+				break
+			}
+			if b[0] != recordTypeHandshake {
+				return "", ErrNotMatched
+			}
+			return "", ErrNeedMore
+		}
+		if b[0] != recordTypeHandshake || b[1] != 3 {
+			return "", ErrNotMatched
+		}
+		length := int(binary.BigEndian.Uint16(b[3:5]))
+		if length == 0 || length > maxRecordLength {
+			return "", ErrNotMatched
+		}
+		b = b[5:]
+		if len(b) < length {
+			// 记录尚未接收完整，已到达的部分仍可解析
+			handshake = append(handshake, b...)
+			b = nil
+			break
+		}
+		handshake = append(handshake, b[:length]...)
+		b = b[length:]
+	}
+	if len(handshake) == 0 {
+		return "", ErrNeedMore
+	}
+	return clientHelloSNI(handshake)
+}
+
+// clientHelloSNI 解析握手消息中的 ClientHello 并提取 server_name 扩展
+// TLS 与 QUIC (CRYPTO 帧) 共用
+func clientHelloSNI(msg []byte) (string, error) {
+	if msg[0] != handshakeTypeClientHello {
+		return "", ErrNotMatched
+	}
+	if len(msg) < 4 {
+		return "", ErrNeedMore
+	}
+	length := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+	if len(msg) < 4+length {
+		return "", ErrNeedMore
+	}
+	r := reader(msg[4 : 4+length])
+
+	// legacy_version(2) + random(32)
+	if !r.skip(34) {
+		return "", ErrNotMatched
+	}
+	sessionID, ok := r.vector8()
+	if !ok || len(sessionID) > 32 {
+		return "", ErrNotMatched
+	}
+	if _, ok := r.vector16(); !ok { // cipher_suites
+		return "", ErrNotMatched
+	}
+	if _, ok := r.vector8(); !ok { // compression_methods
+		return "", ErrNotMatched
+	}
+	exts, ok := r.vector16()
+	if !ok {
+		return "", ErrNotMatched
+	}
+	for len(exts) > 0 {
+		extType, ok1 := exts.uint16()
+		data, ok2 := exts.vector16()
+		if !ok1 || !ok2 {
+			return "", ErrNotMatched
+		}
+		if extType != extensionServerName {
+			continue
+		}
+		names, ok := data.vector16()
+		if !ok {
+			return "", ErrNotMatched
+		}
+		for len(names) > 0 {
+			nameType, ok1 := names.uint8()
+			name, ok2 := names.vector16()
+			if !ok1 || !ok2 {
+				return "", ErrNotMatched
+			}
+			if nameType == serverNameTypeHostName {
+				return validDomain(string(name))
+			}
+		}
+	}
+	return "", ErrNotMatched
+}
+
+// reader 按 TLS 表示语言读取定长整数与变长向量
+type reader []byte
+
+func (r *reader) skip(n int) bool {
+	if n < 0 || len(*r) < n {
+		return false
+	}
+	*r = (*r)[n:]
+	return true
+}
+
+func (r *reader) uint8() (uint8, bool) {
+	if len(*r) < 1 {
+		return 0, false
+	}
+	v := (*r)[0]
+	*r = (*r)[1:]
+	return v, true
+}
+
+func (r *reader) uint16() (uint16, bool) {
+	if len(*r) < 2 {
+		return 0, false
+	}
+	v := binary.BigEndian.Uint16(*r)
+	*r = (*r)[2:]
+	return v, true
+}
+
+func (r *reader) bytes(n int) (reader, bool) {
+	if n < 0 || len(*r) < n {
+		return nil, false
+	}
+	v := (*r)[:n]
+	*r = (*r)[n:]
+	return v, true
+}
+
+func (r *reader) vector8() (reader, bool) {
+	n, ok := r.uint8()
+	if !ok {
+		return nil, false
+	}
+	return r.bytes(int(n))
+}
+
+func (r *reader) vector16() (reader, bool) {
+	n, ok := r.uint16()
+	if !ok {
+		return nil, false
+	}
+	return r.bytes(int(n))
+}
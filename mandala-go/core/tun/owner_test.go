@@ -0,0 +1,136 @@
+//go:build linux
+
+package tun
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"mandala/core/config"
+	"mandala/core/protocol"
+	"mandala/core/route"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// stubOwnerResolver 记录每次查询的参数，并按 "网络 源地址" 返回预设的归属
+type stubOwnerResolver struct {
+	owners map[string]appOwner
+	calls  []string
+}
+
+type appOwner struct {
+	uid int
+	pkg string
+}
+
+func (s *stubOwnerResolver) ResolveOwner(network, source, destination string) (int, string) {
+	s.calls = append(s.calls, network+" "+source+" "+destination)
+	if o, ok := s.owners[network+" "+source]; ok {
+		return o.uid, o.pkg
+	}
+	return route.UnknownUID, ""
+}
+
+type namedOutbound string
+
+func (namedOutbound) DialTCP(ctx context.Context, dest protocol.Destination) (net.Conn, error) {
+	return nil, nil
+}
+
+func (namedOutbound) DialUDP(ctx context.Context, dest protocol.Destination) (net.Conn, error) {
+	return nil, nil
+}
+
+// endpointID 构造网络栈转发请求中的连接标识: Remote 为 TUN 内应用的源地址，Local 为其访问的目标
+func endpointID(src string, srcPort uint16, dst string, dstPort uint16) stack.TransportEndpointID {
+	return stack.TransportEndpointID{
+		RemoteAddress: tcpip.AddrFromSlice(ipBytes(src)),
+		RemotePort:    srcPort,
+		LocalAddress:  tcpip.AddrFromSlice(ipBytes(dst)),
+		LocalPort:     dstPort,
+	}
+}
+
+func ipBytes(s string) []byte {
+	ip := net.ParseIP(s)
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip
+}
+
+func TestStackResolveOwnerRouting(t *testing.T) {
+	proxy, streaming := namedOutbound("proxy"), namedOutbound("streaming")
+	router, err := route.NewRouter(&config.RouteConfig{
+		Rules: []config.RuleConfig{
+			{PackageName: []string{"com.example.bank"}, Action: "direct"},
+			{UID: []int{10077}, Network: []string{"udp"}, Action: "block"},
+			{PackageName: []string{"com.example.video"}, Action: "proxy:streaming"},
+		},
+	}, proxy, map[string]protocol.Outbound{"streaming": streaming})
+	if err != nil {
+		t.Fatal(err)
+	}
+	direct, _ := router.Outbound("direct")
+
+	owner := &stubOwnerResolver{owners: map[string]appOwner{
+		"tcp 10.0.0.2:40001":  {10050, "com.example.bank"},
+		"udp 10.0.0.2:40002":  {10077, "com.example.game"},
+		"tcp 10.0.0.2:40002":  {10077, "com.example.game"},
+		"tcp [fd00::2]:40003": {10066, "com.example.video"},
+	}}
+	s := &Stack{router: router}
+	s.SetOwnerResolver(owner)
+
+	cases := []struct {
+		network string
+		id      stack.TransportEndpointID
+		query   string
+		want    protocol.Outbound
+	}{
+		{"tcp", endpointID("10.0.0.2", 40001, "203.0.113.10", 443), "tcp 10.0.0.2:40001 203.0.113.10:443", direct},
+		{"udp", endpointID("10.0.0.2", 40002, "203.0.113.10", 443), "udp 10.0.0.2:40002 203.0.113.10:443", nil},
+		{"tcp", endpointID("10.0.0.2", 40002, "203.0.113.10", 443), "tcp 10.0.0.2:40002 203.0.113.10:443", proxy},
+		{"tcp", endpointID("fd00::2", 40003, "2001:db8::1", 443), "tcp [fd00::2]:40003 [2001:db8::1]:443", streaming},
+	}
+	for i, c := range cases {
+		dest := protocol.Destination{Host: net.IP(c.id.LocalAddress.AsSlice()).String(), Port: int(c.id.LocalPort)}
+		metadata := route.NewMetadata(c.network, route.InboundTun, dest)
+		s.resolveOwner(metadata, c.id)
+
+		if len(owner.calls) != i+1 || owner.calls[i] != c.query {
+			t.Fatalf("resolver calls = %q, want last %q", owner.calls, c.query)
+		}
+		got, err := router.Pick(metadata)
+		if c.want == nil {
+			if err != route.ErrBlocked {
+				t.Errorf("%s: got %v, %v, want blocked", c.query, got, err)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("%s: got %v, %v, want %v", c.query, got, err, c.want)
+		}
+	}
+}
+
+func TestStackResolveOwnerSkippedWithoutOwnerRules(t *testing.T) {
+	router, err := route.NewRouter(&config.RouteConfig{
+		Rules: []config.RuleConfig{{DomainSuffix: []string{"example.com"}, Action: "direct"}},
+	}, namedOutbound("proxy"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := &stubOwnerResolver{}
+	s := &Stack{router: router}
+	s.SetOwnerResolver(owner)
+
+	metadata := route.NewMetadata("tcp", route.InboundTun, protocol.Destination{Host: "203.0.113.10", Port: 443})
+	s.resolveOwner(metadata, endpointID("10.0.0.2", 40001, "203.0.113.10", 443))
+	if len(owner.calls) != 0 || metadata.UID != route.UnknownUID {
+		t.Fatalf("resolver called without owner rules: %q, uid %d", owner.calls, metadata.UID)
+	}
+}
@@ -6,13 +6,16 @@ import (
 	"io"
 	"log"
 	"net"
-	"strings"
+	"strconv"
 	"sync"
 	"time"
 
 	"mandala/core/config"
+	"mandala/core/dns"
 	"mandala/core/protocol"
 	"mandala/core/proxy"
+	"mandala/core/route"
+	"mandala/core/sniff"
 
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
@@ -32,16 +35,25 @@ func init() {
 type Stack struct {
 	stack     *stack.Stack
 	device    *Device
-	dialer    *proxy.Dialer
-	config    *config.OutboundConfig
+	outbound  protocol.Outbound // 默认代理出站 (DNS 等)
+	outbounds *proxy.OutboundSet
+	router    *route.Router
+	dns       *dns.Handler
+	dnsCache  *dns.Cache     // 未启用缓存时为 nil
+	sniffer   *sniff.Sniffer // 未启用嗅探时为 nil
+	resolver  *dns.Resolver
+	config    *config.Config
 	nat       *UDPNatManager
 	ctx       context.Context
 	cancel    context.CancelFunc
 	closeOnce sync.Once
+
+	ownerMu sync.RWMutex
+	owner   route.OwnerResolver // 连接归属查询，仅在路由规则含应用条件时使用
 }
 
-func StartStack(fd int, mtu int, cfg *config.OutboundConfig) (*Stack, error) {
-	log.Printf("[Stack] 启动中 (FD: %d, MTU: %d, Type: %s)", fd, mtu, cfg.Type)
+func StartStack(fd int, mtu int, cfg *config.Config) (*Stack, error) {
+	log.Printf("[Stack] 启动中 (FD: %d, MTU: %d)", fd, mtu)
 
 	dev, err := NewDevice(fd, uint32(mtu))
 	if err != nil {
@@ -77,19 +89,73 @@ func StartStack(fd int, mtu int, cfg *config.OutboundConfig) (*Stack, error) {
 		{Destination: header.IPv6EmptySubnet, NIC: nicID},
 	})
 
+	// 直连流量绑定物理网卡或打标记，避免再次进入 TUN
+	protocol.SetSocketBinding(cfg.RoutingMark, cfg.BindInterface)
+	if len(cfg.BootstrapDNS) > 0 {
+		protocol.SetResolverServers(cfg.BootstrapDNS)
+	}
+
+	outbounds, err := proxy.NewOutboundSet(cfg)
+	if err != nil {
+		s.Close()
+		dev.Close()
+		return nil, err
+	}
+
+	router, err := route.NewRouter(cfg.Route, outbounds.Default(), outbounds.Tagged())
+	if err != nil {
+		outbounds.Close()
+		s.Close()
+		dev.Close()
+		return nil, err
+	}
+
+	// 未配置上游时 DNS 默认经由代理以 TCP 查询 8.8.8.8
+	resolver, err := dns.NewResolver(cfg.DNS, router.Outbound)
+	if err != nil {
+		outbounds.Close()
+		s.Close()
+		dev.Close()
+		return nil, err
+	}
+	var upstream dns.Upstream = resolver
+	var dnsCache *dns.Cache
+	if cfg.DNS == nil || !cfg.DNS.DisableCache {
+		dnsCache = dns.NewCache(cfg.DNS, resolver)
+		upstream = dnsCache
+	}
+	dnsHandler, err := dns.NewHandler(cfg.DNS, upstream)
+	if err != nil {
+		outbounds.Close()
+		s.Close()
+		dev.Close()
+		return nil, err
+	}
+	// 命中 block 规则的域名在 DNS 阶段即拒绝
+	dnsHandler.SetBlocker(router.BlocksDomain)
+
 	ctx, cancel := context.WithCancel(context.Background())
-	dialer := proxy.NewDialer(cfg)
 
 	tStack := &Stack{
-		stack:  s,
-		device: dev,
-		dialer: dialer,
-		config: cfg,
-		nat:    NewUDPNatManager(dialer, cfg),
-		ctx:    ctx,
-		cancel: cancel,
+		stack:     s,
+		device:    dev,
+		outbound:  outbounds.Default(),
+		outbounds: outbounds,
+		router:    router,
+		dns:       dnsHandler,
+		dnsCache:  dnsCache,
+		resolver:  resolver,
+		sniffer:   sniff.New(cfg.Sniff),
+		owner:     route.NewProcOwnerResolver(),
+		config:    cfg,
+		nat:       NewUDPNatManager(ctx),
+		ctx:       ctx,
+		cancel:    cancel,
 	}
 
+	// ECH 密钥查询与 TUN 共用同一套 DNS 上游
+	proxy.SetECHResolver(upstream)
+
 	tStack.startPacketHandling()
 	return tStack, nil
 }
@@ -115,65 +181,112 @@ func (s *Stack) handleTCP(r *tcp.ForwarderRequest) {
 
 	id := r.ID()
 
-	// 1. 拨号代理
-	remoteConn, dialErr := s.dialer.Dial()
-	if dialErr != nil {
+	dest, ok := s.destination(id.LocalAddress, id.LocalPort)
+	if !ok {
 		r.Complete(true)
 		return
 	}
+	metadata := route.NewMetadata("tcp", route.InboundTun, dest)
+	s.resolveOwner(metadata, id)
 
-	// 2. 握手逻辑
-	var payload []byte
-	var hErr error
-	targetHost := id.LocalAddress.String()
-	targetPort := int(id.LocalPort)
-	isVless := false
-
-	switch strings.ToLower(s.config.Type) {
-	case "mandala":
-		client := protocol.NewMandalaClient(s.config.Username, s.config.Password)
-		payload, hErr = client.BuildHandshakePayload(targetHost, targetPort, s.config.Settings.Noise)
-	case "trojan":
-		payload, hErr = protocol.BuildTrojanPayload(s.config.Password, targetHost, targetPort)
-	case "vless":
-		payload, hErr = protocol.BuildVlessPayload(s.config.UUID, targetHost, targetPort)
-		isVless = true
-	case "shadowsocks":
-		payload, hErr = protocol.BuildShadowsocksPayload(targetHost, targetPort)
-	case "socks", "socks5":
-		hErr = protocol.HandshakeSocks5(remoteConn, s.config.Username, s.config.Password, targetHost, targetPort)
-	}
-
-	if hErr != nil {
-		remoteConn.Close()
-		r.Complete(true)
-		return
-	}
-
-	if len(payload) > 0 {
-		if _, err := remoteConn.Write(payload); err != nil {
-			remoteConn.Close()
-			r.Complete(true)
+	// 目标只有 IP 时先完成本地握手，从客户端首包中嗅探域名后再选择出站
+	if metadata.Domain == "" && (s.sniffer.Enabled(sniff.ProtocolTLS) || s.sniffer.Enabled(sniff.ProtocolHTTP)) {
+		localConn, ep, err := s.acceptTCP(r)
+		if err != nil {
+			return
+		}
+		conn, result := s.sniffer.SniffConn(localConn)
+		dest = s.applySniff(result, metadata, dest)
+		outbound, err := s.router.Pick(metadata)
+		if err != nil {
+			// 本地握手已完成，拦截时以 RST 终止连接
+			ep.Abort()
+			localConn.Close()
 			return
 		}
+		remoteConn, err := outbound.DialTCP(s.ctx, dest)
+		if err != nil {
+			localConn.Close()
+			return
+		}
+		s.relayTCP(conn, remoteConn)
+		return
 	}
 
-	if isVless {
-		remoteConn = protocol.NewVlessConn(remoteConn)
+	// 1. 按路由选择出站并连接目标 (拨号 + 握手)，成功后才响应客户端的 SYN；拦截或失败时回复 RST
+	outbound, err := s.router.Pick(metadata)
+	if err != nil {
+		r.Complete(true)
+		return
+	}
+	remoteConn, err := outbound.DialTCP(s.ctx, dest)
+	if err != nil {
+		r.Complete(true)
+		return
 	}
 
-	// 3. 建立本地连接
-	var wq waiter.Queue
-	ep, err := r.CreateEndpoint(&wq)
+	// 2. 建立本地连接
+	localConn, _, err := s.acceptTCP(r)
 	if err != nil {
 		remoteConn.Close()
-		r.Complete(true)
 		return
 	}
+	s.relayTCP(localConn, remoteConn)
+}
+
+// acceptTCP 完成与客户端的 TCP 握手，同时返回底层端点以便需要时发送 RST
+func (s *Stack) acceptTCP(r *tcp.ForwarderRequest) (net.Conn, tcpip.Endpoint, error) {
+	var wq waiter.Queue
+	ep, tcpErr := r.CreateEndpoint(&wq)
+	if tcpErr != nil {
+		r.Complete(true)
+		return nil, nil, fmt.Errorf("create endpoint failed: %v", tcpErr)
+	}
 	r.Complete(false)
+	return gonet.NewTCPConn(&wq, ep), ep, nil
+}
 
-	localConn := gonet.NewTCPConn(&wq, ep)
+// SetOwnerResolver 设置连接归属查询，传入 nil 表示不再查询
+func (s *Stack) SetOwnerResolver(r route.OwnerResolver) {
+	s.ownerMu.Lock()
+	s.owner = r
+	s.ownerMu.Unlock()
+}
+
+// resolveOwner 在路由规则需要时查询连接所属的应用
+func (s *Stack) resolveOwner(metadata *route.Metadata, id stack.TransportEndpointID) {
+	if !s.router.NeedsOwner() {
+		return
+	}
+	s.ownerMu.RLock()
+	owner := s.owner
+	s.ownerMu.RUnlock()
+	if owner == nil {
+		return
+	}
+	source := net.JoinHostPort(net.IP(id.RemoteAddress.AsSlice()).String(), strconv.Itoa(int(id.RemotePort)))
+	destination := net.JoinHostPort(net.IP(id.LocalAddress.AsSlice()).String(), strconv.Itoa(int(id.LocalPort)))
+	metadata.UID, metadata.Package = owner.ResolveOwner(metadata.Network, source, destination)
+}
 
+// applySniff 将嗅探到的域名写入路由元数据，开启覆盖时同时替换连接目标
+func (s *Stack) applySniff(result sniff.Result, metadata *route.Metadata, dest protocol.Destination) protocol.Destination {
+	if result.Domain == "" {
+		return dest
+	}
+	metadata.Domain = result.Domain
+	if result.Override {
+		dest.Host = result.Domain
+	}
+	if s.config.Debug {
+		log.Printf("[Sniff] %s %s -> %s (覆盖: %v)", result.Protocol, metadata.IP, result.Domain, result.Override)
+	}
+	return dest
+}
+
+// relayTCP 在本地与远端连接之间双向转发
+// 分组出站的健康状态由其返回的连接在首次读取时自行上报
+func (s *Stack) relayTCP(localConn, remoteConn net.Conn) {
 	// 双向关闭逻辑
 	closeAll := func() {
 		localConn.Close()
@@ -216,24 +329,51 @@ func (s *Stack) handleUDP(r *udp.ForwarderRequest) {
 	targetIP := net.IP(id.LocalAddress.AsSlice()).String()
 	srcKey := fmt.Sprintf("%s:%d->%s:%d", id.RemoteAddress.String(), id.RemotePort, targetIP, targetPort)
 
+	dest, ok := s.destination(id.LocalAddress, id.LocalPort)
+	if !ok {
+		return
+	}
+	metadata := route.NewMetadata("udp", route.InboundTun, dest)
+	s.resolveOwner(metadata, id)
+
 	var wq waiter.Queue
-	ep, err := r.CreateEndpoint(&wq)
-	if err != nil {
+	ep, epErr := r.CreateEndpoint(&wq)
+	if epErr != nil {
 		return
 	}
 
 	localConn := gonet.NewUDPConn(s.stack, &wq, ep)
 
-	session, natErr := s.nat.GetOrCreate(srcKey, localConn, targetIP, targetPort)
+	// UDP 443 可能是 QUIC，先读取首批数据报嗅探 SNI
+	var pending [][]byte
+	if targetPort == 443 && metadata.Domain == "" && s.sniffer.Enabled(sniff.ProtocolQUIC) {
+		var result sniff.Result
+		pending, result = s.sniffer.SniffPackets(localConn)
+		dest = s.applySniff(result, metadata, dest)
+	}
+
+	outbound, err := s.router.Pick(metadata)
+	if err != nil {
+		localConn.Close()
+		return
+	}
+
+	session, natErr := s.nat.GetOrCreate(srcKey, localConn, outbound, dest)
 	if natErr != nil {
 		localConn.Close()
 		return
 	}
+	for _, p := range pending {
+		if _, err := session.RemoteConn.Write(p); err != nil {
+			localConn.Close()
+			return
+		}
+	}
 
 	// NAT 转发维持
 	go func() {
 		defer localConn.Close()
-		buf := make([]byte, 4096)
+		buf := make([]byte, 65535)
 		for {
 			localConn.SetDeadline(time.Now().Add(60 * time.Second))
 			n, rErr := localConn.Read(buf)
@@ -258,7 +398,7 @@ func (s *Stack) handleRemoteDNS(localConn *gonet.UDPConn) {
 			localConn.Close()
 		}
 	}()
-	
+
 	localConn.SetDeadline(time.Now().Add(5 * time.Second))
 	buf := make([]byte, 1500)
 	n, err := localConn.Read(buf)
@@ -266,79 +406,47 @@ func (s *Stack) handleRemoteDNS(localConn *gonet.UDPConn) {
 		return
 	}
 
-	// 1. 建立新连接
-	proxyConn, err := s.dialer.Dial()
+	// 交由 DNS 模块处理 (Fake-IP 或经由代理查询上游)
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
+	defer cancel()
+	resp, err := s.dns.Exchange(ctx, buf[:n])
 	if err != nil {
-		log.Printf("[DNS] 代理拨号失败: %v", err)
-		return
-	}
-	if proxyConn == nil {
 		return
 	}
-	defer proxyConn.Close()
-
-	// 2. 握手
-	var payload []byte
-	isVless := false
-	
-	switch strings.ToLower(s.config.Type) {
-	case "mandala":
-		client := protocol.NewMandalaClient(s.config.Username, s.config.Password)
-		payload, _ = client.BuildHandshakePayload("8.8.8.8", 53, s.config.Settings.Noise)
-	case "trojan":
-		payload, _ = protocol.BuildTrojanPayload(s.config.Password, "8.8.8.8", 53)
-	case "vless":
-		payload, _ = protocol.BuildVlessPayload(s.config.UUID, "8.8.8.8", 53)
-		isVless = true
-	case "shadowsocks":
-		payload, _ = protocol.BuildShadowsocksPayload("8.8.8.8", 53)
-	case "socks", "socks5":
-		if err := protocol.HandshakeSocks5(proxyConn, s.config.Username, s.config.Password, "8.8.8.8", 53); err != nil {
-			log.Printf("[DNS] Socks5 握手失败: %v", err)
-			return
-		}
-	}
+	localConn.Write(resp)
+}
 
-	if len(payload) > 0 {
-		if _, err := proxyConn.Write(payload); err != nil {
-			return
+// destination 根据 TUN 中的目标地址构造出站目标，虚假 IP 还原为域名
+// 虚假 IP 的映射已失效时返回 false
+func (s *Stack) destination(addr tcpip.Address, port uint16) (protocol.Destination, bool) {
+	ip := net.IP(addr.AsSlice())
+	dest := protocol.Destination{Host: ip.String(), Port: int(port)}
+	if domain, fake := s.dns.RestoreDomain(ip); fake {
+		if domain == "" {
+			log.Printf("[DNS] 虚假 IP 映射已失效: %s", ip)
+			return dest, false
 		}
+		dest.Host = domain
 	}
+	return dest, true
+}
 
-	var finalConn net.Conn = proxyConn
-	if isVless {
-		finalConn = protocol.NewVlessConn(proxyConn)
-	}
-
-	// 3. 转发 DNS 请求 (RFC 1035 TCP DNS 格式)
-	reqData := make([]byte, 2+n)
-	reqData[0] = byte(n >> 8)
-	reqData[1] = byte(n)
-	copy(reqData[2:], buf[:n])
-
-	finalConn.SetDeadline(time.Now().Add(5 * time.Second))
-	if _, err := finalConn.Write(reqData); err != nil {
-		return
-	}
+// SelectOutbound 切换 selector 分组的当前成员，仅影响之后新建的连接
+func (s *Stack) SelectOutbound(tag string) error {
+	return s.outbounds.Select(tag)
+}
 
-	// 4. 读取响应长度
-	lenBuf := make([]byte, 2)
-	if _, err := io.ReadFull(finalConn, lenBuf); err != nil {
-		return
-	}
-	respLen := int(lenBuf[0])<<8 | int(lenBuf[1])
-	if respLen <= 0 || respLen > 1500 {
-		return
-	}
+// Latencies 返回各出站的最近测速结果
+func (s *Stack) Latencies() map[string]int {
+	return s.outbounds.Latencies()
+}
 
-	// 5. 读取响应体
-	respBuf := make([]byte, respLen)
-	if _, err := io.ReadFull(finalConn, respBuf); err != nil {
-		return
+// DNSStats 返回 DNS 缓存统计
+func (s *Stack) DNSStats() dns.CacheStats {
+	if s.dnsCache == nil {
+		return dns.CacheStats{}
 	}
-
-	// 6. 写回本地
-	localConn.Write(respBuf)
+	return s.dnsCache.Stats()
 }
 
 func (s *Stack) Close() {
@@ -349,6 +457,18 @@ func (s *Stack) Close() {
 			s.cancel()
 		}
 
+		proxy.SetECHResolver(nil)
+		if s.dnsCache != nil {
+			s.dnsCache.Close()
+		}
+		if s.resolver != nil {
+			s.resolver.Close()
+		}
+
+		if s.outbounds != nil {
+			s.outbounds.Close()
+		}
+
 		time.Sleep(100 * time.Millisecond)
 
 		if s.device != nil {
@@ -1,16 +1,14 @@
 package tun
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
-	"strings"
 	"sync"
 	"time"
 
-	"mandala/core/config"
 	"mandala/core/protocol"
-	"mandala/core/proxy"
 
 	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
 )
@@ -28,20 +26,20 @@ type UDPSession struct {
 
 type UDPNatManager struct {
 	sessions sync.Map
-	dialer   *proxy.Dialer
-	config   *config.OutboundConfig
+	ctx      context.Context
 }
 
-func NewUDPNatManager(dialer *proxy.Dialer, cfg *config.OutboundConfig) *UDPNatManager {
+func NewUDPNatManager(ctx context.Context) *UDPNatManager {
 	m := &UDPNatManager{
-		dialer: dialer,
-		config: cfg,
+		ctx: ctx,
 	}
 	go m.cleanupLoop()
 	return m
 }
 
-func (m *UDPNatManager) GetOrCreate(key string, localConn *gonet.UDPConn, targetIP string, targetPort int) (*UDPSession, error) {
+// GetOrCreate 获取或创建 NAT 会话，outbound 为路由选出的出站 (仅在新建会话时使用)
+// dest 中的虚假 IP 已由调用方还原为域名
+func (m *UDPNatManager) GetOrCreate(key string, localConn *gonet.UDPConn, outbound protocol.Outbound, dest protocol.Destination) (*UDPSession, error) {
 	// 构造新 Session 占位符
 	newSession := &UDPSession{
 		LocalConn:  localConn,
@@ -92,49 +90,12 @@ func (m *UDPNatManager) GetOrCreate(key string, localConn *gonet.UDPConn, target
 		return nil, err
 	}
 
-	remoteConn, err := m.dialer.Dial()
+	// 通过出站协议建立 UDP 会话 (拨号 + 握手)
+	remoteConn, err := outbound.DialUDP(m.ctx, dest)
 	if err != nil {
 		return fail(err)
 	}
 
-	var payload []byte
-	var hErr error
-	isVless := false
-
-	// 根据配置类型执行不同的握手逻辑
-	switch strings.ToLower(m.config.Type) {
-	case "mandala":
-		client := protocol.NewMandalaClient(m.config.Username, m.config.Password)
-		payload, hErr = client.BuildHandshakePayload(targetIP, targetPort, m.config.Settings.Noise)
-	case "trojan":
-		payload, hErr = protocol.BuildTrojanPayload(m.config.Password, targetIP, targetPort)
-	case "vless":
-		payload, hErr = protocol.BuildVlessPayload(m.config.UUID, targetIP, targetPort)
-		isVless = true
-	case "shadowsocks":
-		payload, hErr = protocol.BuildShadowsocksPayload(targetIP, targetPort)
-	case "socks", "socks5":
-		hErr = protocol.HandshakeSocks5(remoteConn, m.config.Username, m.config.Password, targetIP, targetPort)
-	}
-
-	if hErr != nil {
-		remoteConn.Close()
-		return fail(hErr)
-	}
-
-	// 发送握手 Payload
-	if len(payload) > 0 {
-		if _, err := remoteConn.Write(payload); err != nil {
-			remoteConn.Close()
-			return fail(err)
-		}
-	}
-
-	// 协议包装（针对 VLESS 剥离头部）
-	if isVless {
-		remoteConn = protocol.NewVlessConn(remoteConn)
-	}
-
 	// 初始化成功，赋值并广播状态
 	newSession.RemoteConn = remoteConn
 	close(newSession.ready) 
@@ -153,7 +114,8 @@ func (m *UDPNatManager) copyRemoteToLocal(key string, s *UDPSession) {
 		m.sessions.Delete(key)
 	}()
 	
-	buf := make([]byte, 4096)
+	// RemoteConn 由出站协议完成 UDP 解帧，每次 Read 返回一个完整数据报
+	buf := make([]byte, 65535)
 	for {
 		if s.RemoteConn == nil {
 			return
@@ -173,7 +135,13 @@ func (m *UDPNatManager) copyRemoteToLocal(key string, s *UDPSession) {
 
 func (m *UDPNatManager) cleanupLoop() {
 	ticker := time.NewTicker(15 * time.Second)
-	for range ticker.C {
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		}
 		now := time.Now()
 		m.sessions.Range(func(key, value interface{}) bool {
 			session := value.(*UDPSession)
@@ -20,6 +20,9 @@ require (
 	// 网络库
 	golang.org/x/net v0.27.0
 
+	// 加密库 (Shadowsocks AEAD / HKDF)
+	golang.org/x/crypto v0.25.0
+
 	// 项目依赖
 	golang.org/x/sys v0.22.0
 	golang.org/x/time v0.5.0
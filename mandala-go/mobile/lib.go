@@ -5,12 +5,55 @@ import (
 	"io"
 	"log"
 	"mandala/core/config"
+	"mandala/core/protocol"
 	"mandala/core/tun"
 	"os"
+	"strings"
 )
 
 var stack *tun.Stack
 
+// ownerResolver 由 Android 端设置的连接归属查询，VPN 启动后生效
+var ownerResolver ConnectionOwnerResolver
+
+// ConnectionOwnerResolver 由 Android 端实现，用于按应用分流 (路由规则中的 package_name / uid)
+type ConnectionOwnerResolver interface {
+	// GetConnectionOwnerUid 对应 ConnectivityManager.getConnectionOwnerUid
+	// protocol 为 6 (TCP) 或 17 (UDP)，source 为应用一侧的地址；无法确定时返回 -1
+	GetConnectionOwnerUid(protocol int, sourceIP string, sourcePort int, destIP string, destPort int) int
+	// GetPackageName 根据 UID 返回包名 (PackageManager.getNameForUid)，未知时返回空字符串
+	GetPackageName(uid int) string
+}
+
+// SocketProtector 由 Android 端实现，对应 VpnService.protect(fd)
+type SocketProtector interface {
+	Protect(fd int) bool
+}
+
+// SetSocketProtector 设置套接字保护，核心发起的所有出站套接字 (代理服务器、直连、DoH) 在连接前调用 Protect 以绕过 VPN，
+// 设置后 App 自身无需通过 addDisallowedApplication 排除；传入 nil 取消
+func SetSocketProtector(p SocketProtector) {
+	if p == nil {
+		protocol.SetSocketProtector(nil)
+		return
+	}
+	protocol.SetSocketProtector(p.Protect)
+}
+
+// SetBootstrapDNS 设置核心自身解析代理服务器与直连目标域名使用的 DNS 服务器，多个以逗号分隔，传入空字符串恢复系统配置
+// Android 上没有可用的 /etc/resolv.conf，应在 StartVpn 前传入底层网络的 DNS 服务器；配置中的 bootstrap_dns 优先
+func SetBootstrapDNS(servers string) {
+	protocol.SetResolverServers(strings.Split(servers, ","))
+}
+
+// SetConnectionOwnerResolver 设置连接归属查询，可在 StartVpn 前后调用，传入 nil 取消
+func SetConnectionOwnerResolver(r ConnectionOwnerResolver) {
+	ownerResolver = r
+	if stack != nil {
+		stack.SetOwnerResolver(newOwnerAdapter(r))
+	}
+}
+
 // [新增] initLog 初始化日志系统，支持文件和控制台双输出
 func initLog(path string) {
 	if path == "" {
@@ -41,27 +84,67 @@ func StartVpn(fd int64, mtu int64, configJson string) string {
 		return "VPN已经在运行"
 	}
 
-	var cfg config.OutboundConfig
-	if err := json.Unmarshal([]byte(configJson), &cfg); err != nil {
+	// 兼容扁平格式的节点 JSON，同时读取 route 等全局字段
+	cfg, err := config.ParseServerConfig(configJson)
+	if err != nil {
 		return "解析配置失败: " + err.Error()
 	}
 
 	// [新增] 初始化日志
-	if cfg.LogPath != "" {
-		initLog(cfg.LogPath)
+	if cfg.CurrentNode != nil && cfg.CurrentNode.LogPath != "" {
+		initLog(cfg.CurrentNode.LogPath)
 	}
 
 	// 转换回 int 使用
-	s, err := tun.StartStack(int(fd), int(mtu), &cfg)
+	s, err := tun.StartStack(int(fd), int(mtu), cfg)
 	if err != nil {
 		log.Printf("启动核心失败: %v", err)
 		return "启动核心失败: " + err.Error()
 	}
 
 	stack = s
+	if ownerResolver != nil {
+		stack.SetOwnerResolver(newOwnerAdapter(ownerResolver))
+	}
+	return ""
+}
+
+// SelectOutbound 切换 selector 分组的当前节点，已有连接不受影响，无需重启 VPN
+// 成功返回空字符串，失败返回错误信息
+func SelectOutbound(tag string) string {
+	if stack == nil {
+		return "VPN未运行"
+	}
+	if err := stack.SelectOutbound(tag); err != nil {
+		return err.Error()
+	}
 	return ""
 }
 
+// GetLatencies 返回各节点最近一次测速结果的 JSON，格式: {"标签": 延迟毫秒}，失败为 -1
+func GetLatencies() string {
+	if stack == nil {
+		return "{}"
+	}
+	data, err := json.Marshal(stack.Latencies())
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// GetDNSStats 返回 DNS 缓存统计的 JSON，格式: {"entries":条目数,"hits":命中,"misses":未命中,"stale":旧应答,"prefetches":后台刷新}
+func GetDNSStats() string {
+	if stack == nil {
+		return "{}"
+	}
+	data, err := json.Marshal(stack.DNSStats())
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
 func Stop() {
 	if stack != nil {
 		log.Println("核心正在停止...")
@@ -0,0 +1,60 @@
+package mobile
+
+import (
+	"net"
+	"strconv"
+	"sync"
+
+	"mandala/core/route"
+)
+
+// ownerAdapter 将 ConnectionOwnerResolver 适配为 route.OwnerResolver，并缓存 UID 对应的包名 (空结果不缓存)
+type ownerAdapter struct {
+	resolver ConnectionOwnerResolver
+	packages sync.Map // uid -> 包名
+}
+
+func newOwnerAdapter(r ConnectionOwnerResolver) route.OwnerResolver {
+	if r == nil {
+		return nil
+	}
+	return &ownerAdapter{resolver: r}
+}
+
+func (a *ownerAdapter) ResolveOwner(network, source, destination string) (int, string) {
+	srcIP, srcPort, ok1 := splitAddr(source)
+	dstIP, dstPort, ok2 := splitAddr(destination)
+	if !ok1 || !ok2 {
+		return route.UnknownUID, ""
+	}
+	protocol := 6
+	if network == "udp" {
+		protocol = 17
+	}
+	uid := a.resolver.GetConnectionOwnerUid(protocol, srcIP, srcPort, dstIP, dstPort)
+	if uid < 0 {
+		return route.UnknownUID, ""
+	}
+
+	if pkg, ok := a.packages.Load(uid); ok {
+		return uid, pkg.(string)
+	}
+	pkg := a.resolver.GetPackageName(uid)
+	// 查询失败或应用尚未安装时不缓存，下次连接重新查询
+	if pkg != "" {
+		a.packages.Store(uid, pkg)
+	}
+	return uid, pkg
+}
+
+func splitAddr(addr string) (string, int, bool) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, false
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, false
+	}
+	return host, port, true
+}
@@ -0,0 +1,48 @@
+package mobile
+
+import "testing"
+
+// fakeOwnerResolver 模拟 Android 端: 包名在安装完成前查询不到
+type fakeOwnerResolver struct {
+	installed    bool
+	packageCalls int
+}
+
+func (f *fakeOwnerResolver) GetConnectionOwnerUid(protocol int, sourceIP string, sourcePort int, destIP string, destPort int) int {
+	if sourcePort == 40000 {
+		return -1
+	}
+	return 10123
+}
+
+func (f *fakeOwnerResolver) GetPackageName(uid int) string {
+	f.packageCalls++
+	if !f.installed {
+		return ""
+	}
+	return "com.example.app"
+}
+
+func TestOwnerAdapterDoesNotCacheEmptyPackage(t *testing.T) {
+	fake := &fakeOwnerResolver{}
+	a := newOwnerAdapter(fake)
+
+	if uid, pkg := a.ResolveOwner("tcp", "10.0.0.2:40001", "1.1.1.1:443"); uid != 10123 || pkg != "" {
+		t.Fatalf("before install: uid=%d pkg=%q", uid, pkg)
+	}
+
+	fake.installed = true
+	if _, pkg := a.ResolveOwner("tcp", "10.0.0.2:40002", "1.1.1.1:443"); pkg != "com.example.app" {
+		t.Fatalf("after install: pkg=%q", pkg)
+	}
+	if _, pkg := a.ResolveOwner("udp", "10.0.0.2:40003", "1.1.1.1:443"); pkg != "com.example.app" {
+		t.Fatalf("cached: pkg=%q", pkg)
+	}
+	if fake.packageCalls != 2 {
+		t.Fatalf("package lookups = %d, want 2", fake.packageCalls)
+	}
+
+	if uid, pkg := a.ResolveOwner("tcp", "10.0.0.2:40000", "1.1.1.1:443"); uid != -1 || pkg != "" {
+		t.Fatalf("unknown owner: uid=%d pkg=%q", uid, pkg)
+	}
+}